@@ -0,0 +1,235 @@
+package avsproperty
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// ArrayElements returns the elements of the Node's array value, or nil
+// if the Node does not contain an array value. If the value is a []any,
+// which is the case for arrays that were read from an XML document, and
+// for arrays of vectors or bools that were read from a binary document,
+// the returned slice is owned by the Node and should not be modified.
+func (n *Node) ArrayElements() []any {
+	if !n.isArray || n.value == nil {
+		return nil
+	}
+	if elems, ok := n.value.([]any); ok {
+		return elems
+	}
+
+	rv := reflect.ValueOf(n.value)
+	elems := make([]any, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems
+}
+
+// ArrayAs returns the elements of the Node's array value as a []T. An
+// error is returned if the Node does not contain an array value, or if
+// the elements are not of type T. Vector elements that were read from
+// a document can be retrieved as arrays, e.g. [2]int32 for 2s32.
+func ArrayAs[T any](n *Node) ([]T, error) {
+	if !n.isArray || n.value == nil {
+		return nil, n.error("node does not contain an array value")
+	}
+	if s, ok := n.value.([]T); ok {
+		return s, nil
+	}
+
+	elems := n.ArrayElements()
+	s := make([]T, len(elems))
+	for i, elem := range elems {
+		if !convertElement(&s[i], elem) {
+			return nil, n.error(fmt.Sprintf("array element of type %T cannot be used as %T", elem, s[i]))
+		}
+	}
+	return s, nil
+}
+
+// AppendElement appends v to the Node's array value. If the Node contains
+// a scalar value of the same type as v, it's turned into an array of both
+// values, and a void Node without children becomes an array that only
+// contains v. v must be of the Go type that corresponds to the Node's type.
+func (n *Node) AppendElement(v any) error {
+	rt, err := n.elementType(v)
+	if err != nil {
+		return err
+	}
+
+	if !n.isArray {
+		if n.nodeType == VoidNode {
+			if len(n.children) > 0 {
+				return n.error("cannot assign value to node that has children")
+			}
+			n.nodeType = typeLut[rt]
+		} else if n.value != nil {
+			// the scalar becomes the first element
+			if reflect.TypeOf(n.value) == rt {
+				s := reflect.MakeSlice(reflect.SliceOf(rt), 1, 2)
+				s.Index(0).Set(reflect.ValueOf(n.value))
+				n.value = s.Interface()
+			} else {
+				// vectors that were read from a document are
+				// stored as arrays of any
+				n.value = []any{n.value}
+			}
+		}
+		n.isArray = true
+	}
+
+	switch elems := n.value.(type) {
+	case nil:
+		n.value = reflect.Append(reflect.MakeSlice(reflect.SliceOf(rt), 0, 1), reflect.ValueOf(v)).Interface()
+	case []any:
+		n.value = append(elems, v)
+	default:
+		n.value = reflect.Append(reflect.ValueOf(elems), reflect.ValueOf(v)).Interface()
+	}
+	return nil
+}
+
+// SetElement replaces the i-th element of the Node's array value with v,
+// which must be of the Go type that corresponds to the Node's type.
+func (n *Node) SetElement(i int, v any) error {
+	if !n.isArray || n.value == nil {
+		return n.error("node does not contain an array value")
+	}
+	if _, err := n.elementType(v); err != nil {
+		return err
+	}
+	if i < 0 || i >= n.ArrayLength() {
+		return n.error("element index out of range: " + strconv.Itoa(i))
+	}
+
+	reflect.ValueOf(n.value).Index(i).Set(reflect.ValueOf(v))
+	return nil
+}
+
+// elementType returns the Go type of v, after checking that it can be
+// used as an element of the Node's array value.
+func (n *Node) elementType(v any) (reflect.Type, error) {
+	rt := reflect.TypeOf(v)
+	pt, ok := typeLut[rt]
+	if !ok {
+		return nil, n.error("invalid Go type")
+	}
+	if pt == StrNode || pt == BinNode {
+		return nil, n.error("invalid array type")
+	}
+	if n.nodeType != VoidNode && n.nodeType.rt != rt {
+		return nil, n.error(fmt.Sprintf("invalid Go type %T for node of type %s", v, n.nodeType.Name()))
+	}
+	return rt, nil
+}
+
+// Value returns the Node's value as a T, and reports whether the value
+// is of type T. Like ArrayAs, vector values that were read from a
+// document can be retrieved as arrays, e.g. [3]float32 for 3f.
+func Value[T any](n *Node) (T, bool) {
+	var v T
+	if value := n.resolvedValue(); value == nil || !convertElement(&v, value) {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+func convertElement[T any](dst *T, elem any) bool {
+	if v, ok := elem.(T); ok {
+		*dst = v
+		return true
+	}
+
+	// vectors are stored as arrays of any
+	rdst, rsrc := reflect.ValueOf(dst).Elem(), reflect.ValueOf(elem)
+	if rdst.Kind() != reflect.Array || rsrc.Kind() != reflect.Array || rdst.Len() != rsrc.Len() {
+		return false
+	}
+	et := rdst.Type().Elem()
+	for i := 0; i < rsrc.Len(); i++ {
+		v := rsrc.Index(i)
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if v.Type() != et {
+			return false
+		}
+		rdst.Index(i).Set(v)
+	}
+	return true
+}
+
+// IntArray returns the elements of the Node's array or vector value as
+// a []int64, or nil if the Node does not contain an array or vector of
+// signed or unsigned integers. Arrays of vectors are flattened. u64
+// values that exceed the range of int64 wrap around.
+func (n *Node) IntArray() []int64 {
+	if !n.isArrayOf(func(kind reflect.Kind) bool {
+		return kind >= reflect.Int8 && kind <= reflect.Int64 || kind >= reflect.Uint8 && kind <= reflect.Uint64
+	}) {
+		return nil
+	}
+
+	s := make([]int64, 0, n.ArrayLength()*n.nodeType.count)
+	n.flattenArray(func(v reflect.Value) {
+		if v.CanInt() {
+			s = append(s, v.Int())
+		} else {
+			s = append(s, int64(v.Uint()))
+		}
+	})
+	return s
+}
+
+// FloatArray returns the elements of the Node's array or vector value as
+// a []float64, or nil if the Node does not contain an array or vector of
+// floats or doubles. Arrays of vectors are flattened.
+func (n *Node) FloatArray() []float64 {
+	if !n.isArrayOf(func(kind reflect.Kind) bool {
+		return kind == reflect.Float32 || kind == reflect.Float64
+	}) {
+		return nil
+	}
+
+	s := make([]float64, 0, n.ArrayLength()*n.nodeType.count)
+	n.flattenArray(func(v reflect.Value) {
+		s = append(s, v.Float())
+	})
+	return s
+}
+
+// isArrayOf reports whether the Node contains an array or vector value
+// whose scalars are of a kind that is accepted by f.
+func (n *Node) isArrayOf(f func(reflect.Kind) bool) bool {
+	rt := n.nodeType.rt
+	if n.value == nil || rt == nil {
+		return false
+	}
+	if rt.Kind() == reflect.Array {
+		rt = rt.Elem()
+	} else if !n.isArray {
+		return false
+	}
+	return f(rt.Kind())
+}
+
+// flattenArray calls f for each scalar in the Node's value.
+func (n *Node) flattenArray(f func(reflect.Value)) {
+	var flatten func(v reflect.Value)
+	flatten = func(v reflect.Value) {
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if kind := v.Kind(); kind != reflect.Slice && kind != reflect.Array {
+			f(v)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			flatten(v.Index(i))
+		}
+	}
+	flatten(reflect.ValueOf(n.value))
+}
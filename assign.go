@@ -0,0 +1,116 @@
+package avsproperty
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+)
+
+var (
+	ipType   = reflect.TypeOf(net.IP{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// AssignTo converts the Node's value to the type of dst, and assigns it
+// to dst, which must be settable. Numeric values can be assigned to any
+// numeric type that represents them exactly, and array and vector values
+// can be assigned to slices and arrays of a suitable length whose
+// elements can be assigned in turn. Additionally, ip4 values can be
+// assigned to strings and uint32s, and time values to time.Time. An
+// error is returned if no conversion exists, in which case dst is left
+// unmodified.
+func (n *Node) AssignTo(dst reflect.Value) error {
+	if !dst.CanSet() {
+		return n.error("destination cannot be set")
+	}
+	if n.nodeType == VoidNode || n.value == nil {
+		return n.error("node does not contain a value")
+	}
+
+	v := n.resolvedValue()
+	tmp := reflect.New(dst.Type()).Elem()
+	if !assignValue(tmp, reflect.ValueOf(v)) {
+		return n.error(fmt.Sprintf("cannot assign value of type %T to %s", v, dst.Type()))
+	}
+	dst.Set(tmp)
+	return nil
+}
+
+func assignValue(dst, src reflect.Value) bool {
+	if src.Kind() == reflect.Interface {
+		src = src.Elem()
+	}
+	if dst.Kind() == reflect.Interface {
+		if !src.Type().AssignableTo(dst.Type()) {
+			return false
+		}
+		dst.Set(src)
+		return true
+	}
+
+	if src.Type() == ipType {
+		ip := src.Interface().(net.IP).To4()
+		switch {
+		case dst.Type() == ipType:
+			dst.Set(reflect.ValueOf(append(net.IP{}, ip...)))
+		case dst.Kind() == reflect.String:
+			dst.SetString(ip.String())
+		case dst.Kind() == reflect.Uint32:
+			dst.SetUint(uint64(binary.BigEndian.Uint32(ip)))
+		case dst.Kind() == reflect.Array && dst.Len() == len(ip) && dst.Type().Elem().Kind() == reflect.Uint8:
+			reflect.Copy(dst, reflect.ValueOf(ip))
+		default:
+			return false
+		}
+		return true
+	}
+	if tv, ok := src.Interface().(TimeValue); ok && dst.Type() == timeType {
+		dst.Set(reflect.ValueOf(time.Unix(int64(tv), 0)))
+		return true
+	}
+
+	switch kind := src.Kind(); kind {
+	case reflect.Slice, reflect.Array:
+		switch dst.Kind() {
+		case reflect.Slice:
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		case reflect.Array:
+			if dst.Len() != src.Len() {
+				return false
+			}
+		default:
+			return false
+		}
+		for i := 0; i < src.Len(); i++ {
+			if !assignValue(dst.Index(i), src.Index(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.String:
+		if dst.Kind() != reflect.String {
+			return false
+		}
+		dst.SetString(src.String())
+		return true
+
+	case reflect.Bool:
+		if dst.Kind() != reflect.Bool {
+			return false
+		}
+		dst.SetBool(src.Bool())
+		return true
+	}
+
+	switch dst.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint,
+		reflect.Float32, reflect.Float64:
+		return convertValue(dst, src, false) == nil
+	default:
+		return false
+	}
+}
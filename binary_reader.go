@@ -3,15 +3,20 @@ package avsproperty
 import (
 	"encoding/binary"
 	"io"
+	"math"
 
 	"golang.org/x/text/encoding"
 )
 
 const (
-	binaryMagic          = 0xA042
-	binaryMagicLong      = 0xA045
-	maxValueSize         = 0x1000000
-	arrayMask       byte = (1 << 6)
+	binaryMagic                 = 0xA042
+	binaryMagicLong             = 0xA045
+	binaryMagicDedupeFlag       = 0x0010
+	binaryMagicCompactFlag      = 0x0020
+	maxCompactSectionSize       = 0xFFFF
+	maxValueSize                = 0x1000000
+	stringRefMask               = 0x80000000
+	arrayMask              byte = (1 << 6)
 
 	maxMetaDepth = 100
 )
@@ -21,30 +26,58 @@ var (
 	errDatabody = propertyError("malformed databody")
 )
 
-func readBinary(prop *Property, rd io.Reader) error {
+// A binary document has the following layout, where all sizes are
+// 32-bit unsigned integers:
+//
+//	header    magic (2 bytes), encoding, and the encoding's complement
+//	metadata  size, followed by the node and attribute names, padded
+//	          to a multiple of 4 bytes
+//	databody  size, followed by the values of the nodes and attributes,
+//	          padded to a multiple of 4 bytes
+//
+// Both sections are preceded by their size, and the sizes do not
+// include the size fields themselves.
+//
+// Two non-standard flags may be set in the magic number: 0x0010 if the
+// DedupeStrings extension is used, and 0x0020 if the section sizes are
+// 16-bit instead of 32-bit unsigned integers (PropertySettings.Compact).
+func readBinary(prop *Property, rd io.Reader, recycler *nodeRecycler) error {
 	prop.Settings.Format = FormatBinary
 	state := binaryReadState{
-		prop: prop,
-		rd:   rd,
+		prop:     prop,
+		rd:       rd,
+		recycler: recycler,
+		order:    prop.byteOrder(),
+	}
+	if prop.source != nil {
+		state.counter = &countingReader{rd: rd}
+		state.rd = state.counter
 	}
 	return state.read()
 }
 
 type binaryReadState struct {
-	rd      io.Reader
-	prop    *Property
-	decoder *encoding.Decoder
+	rd       io.Reader
+	prop     *Property
+	decoder  *encoding.Decoder
+	recycler *nodeRecycler
+	order    binary.ByteOrder
+	counter  *countingReader
 
 	b8, b16 []byte
+	strings []string
 }
 
 func (state *binaryReadState) read() error {
+	trace := state.prop.Settings.Trace
 	if err := state.readHeader(); err != nil {
 		return err
 	}
+	trace.header()
 	if err := state.readMetadata(); err != nil {
 		return err
 	}
+	trace.metadataDone(state.prop.Root)
 	return state.readDatabody()
 }
 
@@ -54,7 +87,15 @@ func (state *binaryReadState) readHeader() error {
 		return err
 	}
 
-	if magic := binary.BigEndian.Uint16(header); magic == binaryMagic {
+	magic := binary.BigEndian.Uint16(header)
+	if state.prop.Settings.DedupeStrings = magic&binaryMagicDedupeFlag != 0; state.prop.Settings.DedupeStrings {
+		magic &^= binaryMagicDedupeFlag
+		state.strings = make([]string, 0)
+	}
+	if state.prop.Settings.Compact = magic&binaryMagicCompactFlag != 0; state.prop.Settings.Compact {
+		magic &^= binaryMagicCompactFlag
+	}
+	if magic == binaryMagic {
 		state.prop.Settings.UseLongNodeNames = false
 	} else if magic == binaryMagicLong {
 		state.prop.Settings.UseLongNodeNames = true
@@ -62,11 +103,23 @@ func (state *binaryReadState) readHeader() error {
 		return propertyError("invalid magic number")
 	}
 
-	if header[2] != ^header[3] {
+	lenient := state.prop.Settings.IgnoreEncodingChecksum
+	if header[2] != ^header[3] && !lenient {
 		return propertyError("invalid encoding checksum")
 	}
-	if state.prop.Settings.Encoding = encodingById(header[2] >> 5); state.prop.Settings.Encoding == nil {
-		return propertyError("invalid encoding")
+	codepage := header[2] >> 5
+	var encoding *Encoding
+	if resolve := state.prop.Settings.EncodingResolver; resolve != nil {
+		encoding = resolve(codepage)
+	}
+	if encoding == nil {
+		encoding = encodingById(codepage)
+	}
+	if state.prop.Settings.Encoding = encoding; encoding == nil {
+		if !lenient {
+			return propertyError("invalid encoding")
+		}
+		state.prop.Settings.Encoding = EncodingNone
 	}
 	state.decoder = state.prop.Encoding().decoder()
 
@@ -100,6 +153,7 @@ func (state *binaryReadState) readMetadata() error {
 			if depth < 0 {
 				return errMetadata
 			}
+			state.recycler.leave()
 			node = node.parent
 			continue
 		}
@@ -120,7 +174,7 @@ func (state *binaryReadState) readMetadata() error {
 		}
 
 		depth++
-		if depth > maxMetaDepth {
+		if depth > state.prop.maxDepth() {
 			return propertyError("max depth exceeded")
 		}
 
@@ -129,10 +183,14 @@ func (state *binaryReadState) readMetadata() error {
 			return errMetadata
 		}
 
-		newNode := &Node{
-			name:     name,
-			nodeType: typ,
-			isArray:  id&arrayMask != 0,
+		isArray := id&arrayMask != 0
+		newNode := state.recycler.next(node, name, typ, isArray)
+		if newNode == nil {
+			newNode = &Node{
+				name:     name,
+				nodeType: typ,
+				isArray:  isArray,
+			}
 		}
 		if node == nil {
 			if state.prop.Root != nil {
@@ -160,10 +218,21 @@ func (state *binaryReadState) readMetadata() error {
 }
 
 func (state *binaryReadState) readDatabody() error {
-	// skip
-	b := make([]byte, 4)
-	state.rd.Read(b)
-	return state.prop.Root.Traverse(state.readDatabodyNode, nil)
+	// the size is only used for tracing, since the values are read
+	// until every node in the tree has one
+	size, err := state.readSectionSize()
+	if err != nil {
+		if err == io.EOF && state.prop.Settings.AllowMissingDatabody {
+			state.prop.Settings.Trace.databodyDone(0)
+			return nil
+		}
+		return err
+	}
+	if err := state.prop.Root.Traverse(state.readDatabodyNode, nil); err != nil {
+		return err
+	}
+	state.prop.Settings.Trace.databodyDone(int(size))
+	return nil
 }
 
 func (state *binaryReadState) readDatabodyNode(node *Node) error {
@@ -186,12 +255,26 @@ func (state *binaryReadState) readDatabodyNode(node *Node) error {
 
 func (state *binaryReadState) readValue(node *Node) (err error) {
 	if node.nodeType == StrNode {
-		s, err := state.readString()
+		if state.prop.Settings.LazyStrings && state.strings == nil {
+			err = state.readLazyString(node)
+		} else {
+			var s string
+			s, err = state.readString()
+			node.value = s
+		}
 		if err != nil {
 			return err
 		}
-		node.value = s
+
+		e := EncodingNone
+		if state.decoder != nil {
+			e = state.prop.Encoding()
+		}
+		state.prop.recordStringEncoding(node, e)
 	} else if node.nodeType == BinNode {
+		if state.counter != nil {
+			return state.readStreamedBinary(node)
+		}
 		b, err := state.readArray()
 		if err != nil {
 			return err
@@ -206,10 +289,15 @@ func (state *binaryReadState) readValue(node *Node) (err error) {
 			return errDatabody
 		}
 
+		if v := decodeNumericArray(node.nodeType, state.order, data); v != nil {
+			node.value = v
+			return nil
+		}
+
 		slice := make([]any, len(data)/node.nodeType.size)
 		for i := range slice {
 			var k any
-			k, err = node.nodeType.btv(data[i*node.nodeType.size:])
+			k, err = node.nodeType.btv(state.order, data[i*node.nodeType.size:])
 			if err != nil {
 				break
 			}
@@ -222,6 +310,45 @@ func (state *binaryReadState) readValue(node *Node) (err error) {
 	return
 }
 
+// decodeNumericArray decodes the elements of an array of a scalar
+// numeric type into a slice of the type's Go type, which avoids boxing
+// every element. nil is returned for all other types.
+func decodeNumericArray(nt *NodeType, o binary.ByteOrder, data []byte) any {
+	switch nt {
+	case S8Node:
+		return decodeSlice(data, 1, func(b []byte) int8 { return int8(b[0]) })
+	case U8Node:
+		return decodeSlice(data, 1, func(b []byte) uint8 { return b[0] })
+	case S16Node:
+		return decodeSlice(data, 2, func(b []byte) int16 { return int16(o.Uint16(b)) })
+	case U16Node:
+		return decodeSlice(data, 2, o.Uint16)
+	case S32Node:
+		return decodeSlice(data, 4, func(b []byte) int32 { return int32(o.Uint32(b)) })
+	case U32Node:
+		return decodeSlice(data, 4, o.Uint32)
+	case S64Node:
+		return decodeSlice(data, 8, func(b []byte) int64 { return int64(o.Uint64(b)) })
+	case U64Node:
+		return decodeSlice(data, 8, o.Uint64)
+	case TimeNode:
+		return decodeSlice(data, 4, func(b []byte) TimeValue { return TimeValue(o.Uint32(b)) })
+	case FloatNode:
+		return decodeSlice(data, 4, func(b []byte) float32 { return math.Float32frombits(o.Uint32(b)) })
+	case DoubleNode:
+		return decodeSlice(data, 8, func(b []byte) float64 { return math.Float64frombits(o.Uint64(b)) })
+	}
+	return nil
+}
+
+func decodeSlice[T any](data []byte, size int, f func([]byte) T) []T {
+	s := make([]T, len(data)/size)
+	for i := range s {
+		s[i] = f(data[i*size:])
+	}
+	return s
+}
+
 func (state *binaryReadState) read32(size int) ([]byte, error) {
 	if size < 0 {
 		return nil, errDatabody
@@ -240,32 +367,114 @@ func (state *binaryReadState) read32(size int) ([]byte, error) {
 	return b[:size], nil
 }
 
-func (state *binaryReadState) readArray() (b []byte, err error) {
-	if b, err = state.read32(4); err != nil {
-		return
+func (state *binaryReadState) readU32() (uint32, error) {
+	b, err := state.read32(4)
+	if err != nil {
+		return 0, err
+	}
+	return state.order.Uint32(b), nil
+}
+
+func (state *binaryReadState) readArray() ([]byte, error) {
+	size, err := state.readU32()
+	if err != nil {
+		return nil, err
 	}
-	size := binary.BigEndian.Uint32(b)
-	if size > maxValueSize {
+	return state.readArrayData(size)
+}
+
+func (state *binaryReadState) readArrayData(size uint32) ([]byte, error) {
+	if int64(size) > int64(state.prop.maxValueSize()) {
 		return nil, errDatabody
 	}
 	return state.read32(int(size))
 }
 
+// readStreamedBinary reads a bin value, which is skipped and left in the
+// source if it's at least as large as PropertySettings.StreamBinaryThreshold.
+func (state *binaryReadState) readStreamedBinary(node *Node) error {
+	size, err := state.readU32()
+	if err != nil {
+		return err
+	}
+	if int64(size) < int64(state.prop.Settings.StreamBinaryThreshold) {
+		b, err := state.readArrayData(size)
+		if err != nil {
+			return err
+		}
+		node.value = BinValue(b)
+		return nil
+	}
+	if int64(size) > int64(state.prop.maxValueSize()) {
+		return errDatabody
+	}
+
+	source := state.prop.source
+	offset := source.offset + state.counter.n
+	aligned := int64(size)
+	if r := aligned % 4; r != 0 {
+		aligned += 4 - r
+	}
+	if _, err := io.CopyN(io.Discard, state.rd, aligned); err != nil {
+		return err
+	}
+	node.value = &streamedBinary{io.NewSectionReader(source, offset, int64(size))}
+	return nil
+}
+
+func (state *binaryReadState) readLazyString(node *Node) error {
+	size, err := state.readU32()
+	if err != nil {
+		return err
+	}
+	b, err := state.readStringData(size)
+	if err != nil {
+		return err
+	}
+	node.value = &lazyString{b, state.prop.Encoding(), state.prop.Settings.LenientDecoding}
+	return nil
+}
+
+// readStringData reads a null-terminated string of the specified size,
+// and returns it without the terminator.
+func (state *binaryReadState) readStringData(size uint32) ([]byte, error) {
+	b, err := state.readArrayData(size)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errDatabody
+	}
+	return b[:len(b)-1], nil
+}
+
 func (state *binaryReadState) readString() (string, error) {
-	b, err := state.readArray()
+	size, err := state.readU32()
 	if err != nil {
 		return "", err
 	}
-	if len(b) == 0 {
-		return "", errDatabody
+	if state.strings != nil && size&stringRefMask != 0 {
+		i := size &^ stringRefMask
+		if i >= uint32(len(state.strings)) {
+			return "", errDatabody
+		}
+		return state.strings[i], nil
+	}
+
+	b, err := state.readStringData(size)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := decodeString(b, state.prop.Encoding(), state.decoder, state.prop.Settings.LenientDecoding)
+	if err != nil {
+		return "", err
 	}
-	b = b[:len(b)-1]
 
-	if state.decoder == nil {
-		return string(b), err
+	if state.strings != nil {
+		state.strings = append(state.strings, s)
 	}
-	s, err := state.decoder.Bytes(b)
-	return string(s), err
+	return s, nil
 }
 
 func (state *binaryReadState) refillBoundary(b []byte) ([]byte, error) {
@@ -303,17 +512,25 @@ func (state *binaryReadState) readAligned(node *Node) (err error) {
 			return
 		}
 	}
-	node.value, err = node.nodeType.btv(data)
+	node.value, err = node.nodeType.btv(state.order, data)
 	return
 }
 
 func (state *binaryReadState) readSectionSize() (int64, error) {
-	data := make([]byte, 4)
-	if _, err := io.ReadFull(state.rd, data); err != nil {
-		return 0, err
+	var size int64
+	if state.prop.Settings.Compact {
+		data := make([]byte, 2)
+		if _, err := io.ReadFull(state.rd, data); err != nil {
+			return 0, err
+		}
+		size = int64(state.order.Uint16(data))
+	} else {
+		data := make([]byte, 4)
+		if _, err := io.ReadFull(state.rd, data); err != nil {
+			return 0, err
+		}
+		size = int64(state.order.Uint32(data))
 	}
-
-	size := int64(binary.BigEndian.Uint32(data))
 	if size%4 != 0 {
 		return 0, propertyError("invalid section alignment")
 	}
@@ -0,0 +1,104 @@
+package avsproperty
+
+import (
+	"bytes"
+	"io"
+)
+
+// streamedBinary refers to the data of a bin node that was read with
+// PropertySettings.StreamBinaryThreshold, which remains in the source.
+type streamedBinary struct {
+	data *io.SectionReader
+}
+
+func (sb *streamedBinary) load() (BinValue, error) {
+	b := make(BinValue, sb.data.Size())
+	if _, err := io.ReadFull(sb.reader(), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (sb *streamedBinary) reader() *io.SectionReader {
+	return io.NewSectionReader(sb.data, 0, sb.data.Size())
+}
+
+// BinaryReader returns a reader for the Node's bin value, or an empty
+// reader if the Node does not contain a bin value. If the value was
+// not loaded because of PropertySettings.StreamBinaryThreshold, it's
+// read directly from the source of the document. Each call returns a
+// new reader that starts at the beginning of the value.
+func (n *Node) BinaryReader() io.Reader {
+	if sb, ok := n.value.(*streamedBinary); ok {
+		return sb.reader()
+	}
+	return bytes.NewReader(n.BinaryValue())
+}
+
+// loadBinary returns the Node's bin value after loading it from the
+// source of the document, if it was streamed.
+func (n *Node) loadBinary() (BinValue, error) {
+	if sb, ok := n.value.(*streamedBinary); ok {
+		b, err := sb.load()
+		if err != nil {
+			return nil, n.valueError(err)
+		}
+		n.value = b
+	}
+	b, _ := n.value.(BinValue)
+	return b, nil
+}
+
+// binarySource is the source of a binary document whose bin values
+// may be streamed.
+type binarySource struct {
+	io.ReaderAt
+	offset int64
+}
+
+// newBinarySource returns the source of the document that is read from
+// rd, or nil if rd is not seekable.
+func newBinarySource(rd io.Reader) *binarySource {
+	ra, ok := rd.(io.ReaderAt)
+	if !ok {
+		return nil
+	}
+	seeker, ok := rd.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return &binarySource{ra, offset}
+}
+
+// countingReader counts the bytes that are read from a document, which
+// determines the offsets of streamed values.
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.rd.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	c, err := cr.rd.(io.ByteReader).ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
+
+func (cr *countingReader) UnreadByte() error {
+	err := cr.rd.(io.ByteScanner).UnreadByte()
+	if err == nil {
+		cr.n--
+	}
+	return err
+}
@@ -5,18 +5,101 @@ import (
 	"io"
 	"reflect"
 	"strconv"
-
-	"golang.org/x/text/encoding"
+	"sync"
 )
 
+// databodyPool holds the databody buffers of previous writes, whose
+// capacity is reused. Buffers larger than maxPooledDatabodySize are
+// not kept, so that a single large document doesn't pin its memory.
+var databodyPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+const maxPooledDatabodySize = 1 << 20
+
 func writeBinary(prop *Property, wr io.Writer) error {
 	prop.Settings.Format = FormatBinary
+	buf := databodyPool.Get().(*[]byte)
+	state := binaryWriteState{
+		prop:     prop,
+		wr:       wr,
+		databody: (*buf)[:0],
+		encoder:  newStringEncoder(prop.Encoding(), &prop.Settings),
+		order:    prop.byteOrder(),
+	}
+	if prop.Settings.DedupeStrings {
+		state.strings = make(map[string]uint32)
+	}
+	err := state.write()
+
+	if cap(state.databody) <= maxPooledDatabodySize {
+		*buf = state.databody[:0]
+		databodyPool.Put(buf)
+	}
+	return err
+}
+
+// ValueBytes returns the bytes that the Node's value occupies in the
+// databody of a binary document that uses the default settings. Array,
+// str, and bin values are prefixed with their size and padded to a
+// multiple of 4 bytes. Since 1- and 2-byte values share 4-byte blocks
+// with other values of the same size, they are returned without
+// padding. Other values are padded to a multiple of 4 bytes.
+func (n *Node) ValueBytes() ([]byte, error) {
+	if n.nodeType == VoidNode {
+		return nil, n.error("void nodes do not have a value")
+	}
+	if n.value == nil {
+		return nil, n.error("node contains a nil value")
+	}
+
+	state := binaryWriteState{
+		prop:    &Property{},
+		encoder: newStringEncoder(EncodingNone, &PropertySettings{}),
+		order:   binary.BigEndian,
+	}
+	if err := state.writeValue(n); err != nil {
+		return nil, err
+	}
+
+	b := state.databody
+	if n.isPacked() {
+		b = b[:n.nodeType.size]
+	}
+	return b, nil
+}
+
+// isPacked reports whether the Node's value shares 4-byte blocks with
+// other values of the same size in the databody.
+func (n *Node) isPacked() bool {
+	size := n.nodeType.size
+	return !n.isArray && n.nodeType != StrNode && n.nodeType != BinNode && (size == 1 || size == 2)
+}
+
+// IsCompactEligible reports whether the sizes of both sections of the
+// Property's binary representation fit into the 16-bit size fields that
+// are used if PropertySettings.Compact is enabled. false is also
+// returned if the Property cannot be written at all.
+func (p *Property) IsCompactEligible() bool {
+	if p.Root == nil {
+		return false
+	}
+
 	state := binaryWriteState{
-		prop:    prop,
-		wr:      wr,
-		encoder: prop.Encoding().encoder(),
+		prop:    p,
+		encoder: newStringEncoder(p.Encoding(), &p.Settings),
+		order:   p.byteOrder(),
+	}
+	if p.Settings.DedupeStrings {
+		state.strings = make(map[string]uint32)
+	}
+
+	if err := state.buildDatabody(); err != nil {
+		return false
 	}
-	return state.write()
+	return state.checkCompact() == nil
 }
 
 type binaryWriteState struct {
@@ -25,21 +108,38 @@ type binaryWriteState struct {
 
 	databody []byte
 	i16, i8  int
-	encoder  *encoding.Encoder
+	encoder  *stringEncoder
+	strings  map[string]uint32
+	order    binary.ByteOrder
 }
 
 func (state *binaryWriteState) write() error {
+	// the databody is built before anything is written, so that its
+	// size can be checked in advance
+	if err := state.buildDatabody(); err != nil {
+		return err
+	}
+	if state.prop.Settings.Compact {
+		if err := state.checkCompact(); err != nil {
+			return err
+		}
+	}
+
+	trace := state.prop.Settings.Trace
 	if err := state.writeHeader(); err != nil {
 		return err
 	}
+	trace.header()
 
 	if err := state.writeMetadata(); err != nil {
 		return err
 	}
+	trace.metadataDone(state.prop.Root)
 
 	if err := state.writeDatabody(); err != nil {
 		return err
 	}
+	trace.databodyDone(len(state.databody))
 	return nil
 }
 
@@ -48,6 +148,12 @@ func (state *binaryWriteState) writeHeader() error {
 	if state.prop.Settings.UseLongNodeNames {
 		magic = binaryMagicLong
 	}
+	if state.prop.Settings.DedupeStrings {
+		magic |= binaryMagicDedupeFlag
+	}
+	if state.prop.Settings.Compact {
+		magic |= binaryMagicCompactFlag
+	}
 	if err := binary.Write(state.wr, binary.BigEndian, uint16(magic)); err != nil {
 		return err
 	}
@@ -63,7 +169,7 @@ func (state *binaryWriteState) writeMetadata() error {
 		return err
 	}
 
-	if err := binary.Write(state.wr, binary.BigEndian, uint32(size)); err != nil {
+	if err := state.writeSectionSize(size); err != nil {
 		return err
 	}
 
@@ -137,12 +243,25 @@ func (state *binaryWriteState) calculateMetadataSize(node *Node) (n int, padding
 	return
 }
 
-func (state *binaryWriteState) writeDatabody() error {
-	if err := state.prop.Root.Traverse(state.writeDatabodyNode, nil); err != nil {
+// checkCompact checks whether the sizes of both sections fit into the
+// size fields of the compact variant, after the databody has been built.
+func (state *binaryWriteState) checkCompact() error {
+	size, _, err := state.calculateMetadataSize(state.prop.Root)
+	if err != nil {
 		return err
 	}
+	if size > maxCompactSectionSize || len(state.databody) > maxCompactSectionSize {
+		return propertyError("property is too large for compact section sizes")
+	}
+	return nil
+}
 
-	if err := binary.Write(state.wr, binary.BigEndian, uint32(len(state.databody))); err != nil {
+func (state *binaryWriteState) buildDatabody() error {
+	return state.prop.Root.Traverse(state.writeDatabodyNode, nil)
+}
+
+func (state *binaryWriteState) writeDatabody() error {
+	if err := state.writeSectionSize(len(state.databody)); err != nil {
 		return err
 	}
 
@@ -150,6 +269,13 @@ func (state *binaryWriteState) writeDatabody() error {
 	return err
 }
 
+func (state *binaryWriteState) writeSectionSize(size int) error {
+	if state.prop.Settings.Compact {
+		return binary.Write(state.wr, state.order, uint16(size))
+	}
+	return binary.Write(state.wr, state.order, uint32(size))
+}
+
 func (state *binaryWriteState) appendPadding() {
 	if r := len(state.databody) % 4; r != 0 {
 		state.databody = append(state.databody, make([]byte, 4-r)...)
@@ -199,18 +325,22 @@ func (state *binaryWriteState) allocate(size int) (b []byte) {
 }
 
 func (state *binaryWriteState) appendU32(i uint32) {
-	state.databody = binary.BigEndian.AppendUint32(state.databody, uint32(i))
+	state.databody = append(state.databody, 0, 0, 0, 0)
+	state.order.PutUint32(state.databody[len(state.databody)-4:], i)
 }
 
 func (state *binaryWriteState) writeString(s string) (err error) {
-	var b []byte
-	if state.encoder == nil {
-		b = []byte(s)
-	} else {
-		b, err = state.encoder.Bytes([]byte(s))
-		if err != nil {
+	if state.strings != nil {
+		if i, ok := state.strings[s]; ok {
+			state.appendU32(i | stringRefMask)
 			return
 		}
+		state.strings[s] = uint32(len(state.strings))
+	}
+
+	b, err := state.encoder.encode(s)
+	if err != nil {
+		return
 	}
 	// null-terminated
 	b = append(b, 0)
@@ -229,12 +359,12 @@ func (state *binaryWriteState) writeArray(node *Node) {
 	state.appendU32(uint32(size))
 	b := state.allocate32(size)
 	for i := 0; i < v.Len(); i++ {
-		node.nodeType.vtb(v.Index(i).Interface(), b[i*nt.size:])
+		node.nodeType.vtb(state.order, v.Index(i).Interface(), b[i*nt.size:])
 	}
 }
 
 func (state *binaryWriteState) writeValue(node *Node) error {
-	if size := node.ArrayLength() * node.nodeType.size; size > maxValueSize {
+	if size := node.ArrayLength() * node.nodeType.size; size > state.prop.maxValueSize() {
 		return node.error("value too large: " + strconv.Itoa(size))
 	}
 
@@ -245,11 +375,14 @@ func (state *binaryWriteState) writeValue(node *Node) error {
 			return err
 		}
 	} else if node.nodeType == BinNode {
-		b := node.BinaryValue()
+		b, err := node.loadBinary()
+		if err != nil {
+			return err
+		}
 		state.appendU32(uint32(len(b)))
 		state.append32(b)
 	} else {
-		node.nodeType.vtb(node.value, state.allocate(node.nodeType.size))
+		node.nodeType.vtb(state.order, node.value, state.allocate(node.nodeType.size))
 	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package avsproperty
+
+import (
+	"math"
+	"reflect"
+)
+
+var (
+	canonicalNaN32 = math.Float32frombits(0x7FC00000)
+	canonicalNaN64 = math.Float64frombits(0x7FF8000000000000)
+)
+
+func (n *Node) canonicalizeFloats() {
+	rt := n.nodeType.rt
+	if rt == nil || n.value == nil {
+		return
+	}
+	if rt.Kind() == reflect.Array {
+		rt = rt.Elem()
+	}
+	if kind := rt.Kind(); kind == reflect.Float32 || kind == reflect.Float64 {
+		n.value = canonicalizeFloats(n.value)
+	}
+}
+
+// canonicalizeFloats returns v with all of its float values canonicalized.
+// Slices are modified in place.
+func canonicalizeFloats(v any) any {
+	switch v := v.(type) {
+	case float32:
+		return canonicalFloat32(v)
+	case float64:
+		return canonicalFloat64(v)
+
+	case []float32:
+		for i := range v {
+			v[i] = canonicalFloat32(v[i])
+		}
+	case []float64:
+		for i := range v {
+			v[i] = canonicalFloat64(v[i])
+		}
+	case []any:
+		for i := range v {
+			v[i] = canonicalizeFloats(v[i])
+		}
+
+	default:
+		// vectors
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Array {
+			return v
+		}
+		vec := reflect.New(rv.Type()).Elem()
+		for i := 0; i < rv.Len(); i++ {
+			vec.Index(i).Set(reflect.ValueOf(canonicalizeFloats(rv.Index(i).Interface())))
+		}
+		return vec.Interface()
+	}
+	return v
+}
+
+func canonicalFloat32(f float32) float32 {
+	if math.IsNaN(float64(f)) {
+		return canonicalNaN32
+	}
+	if f == 0 {
+		return 0
+	}
+	return f
+}
+
+func canonicalFloat64(f float64) float64 {
+	if math.IsNaN(f) {
+		return canonicalNaN64
+	}
+	if f == 0 {
+		return 0
+	}
+	return f
+}
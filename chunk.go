@@ -0,0 +1,98 @@
+package avsproperty
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// ChunkArray splits the Node's array value into children with the
+// specified name, each of which contains at most maxElements elements of
+// the original array, in order. The Node becomes a void node afterwards.
+// This allows arrays that exceed the size limit of the binary format to
+// be written. An empty array results in a single empty child. Refer to
+// JoinChunkedArray for the reverse operation.
+func (n *Node) ChunkArray(name string, maxElements int) error {
+	if !n.isArray || n.value == nil {
+		return n.error("node does not contain an array value")
+	}
+	if maxElements <= 0 {
+		return n.error("invalid number of elements per chunk: " + strconv.Itoa(maxElements))
+	}
+	key, err := NewNodeName(name)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(n.value)
+	var chunks []*Node
+	for i := 0; i == 0 || i < rv.Len(); i += maxElements {
+		end := min(i+maxElements, rv.Len())
+		// copy the elements, so that the chunks do not share
+		// their backing array with the original value
+		value := reflect.AppendSlice(reflect.MakeSlice(rv.Type(), 0, end-i), rv.Slice(i, end))
+		chunks = append(chunks, &Node{
+			parent:   n,
+			name:     key,
+			nodeType: n.nodeType,
+			isArray:  true,
+			value:    value.Interface(),
+		})
+	}
+
+	n.nodeType = VoidNode
+	n.value = nil
+	n.isArray = false
+	n.children = append(n.children, chunks...)
+	return nil
+}
+
+// JoinChunkedArray reverses ChunkArray by concatenating the values of the
+// Node's children, all of which must be arrays of the same type with the
+// specified name. The children are removed, and the Node receives the
+// concatenated array as its value.
+func (n *Node) JoinChunkedArray(name string) error {
+	key, err := NewNodeName(name)
+	if err != nil {
+		return err
+	}
+	if len(n.children) == 0 {
+		return n.error("node does not contain any chunks")
+	}
+
+	nt := n.children[0].nodeType
+	var (
+		rt    = reflect.TypeOf(n.children[0].value)
+		count int
+	)
+	for _, c := range n.children {
+		if !c.name.Equals(key) {
+			return c.error("node is not a chunk")
+		}
+		if !c.isArray || c.value == nil || c.nodeType != nt {
+			return c.error("chunk does not contain an array value of type " + nt.Name())
+		}
+		if reflect.TypeOf(c.value) != rt {
+			// chunks that were created from different sources
+			rt = reflect.TypeOf([]any(nil))
+		}
+		count += c.ArrayLength()
+	}
+
+	value := reflect.MakeSlice(rt, 0, count)
+	for _, c := range n.children {
+		if rt.Elem().Kind() == reflect.Interface {
+			for _, elem := range c.ArrayElements() {
+				value = reflect.Append(value, reflect.ValueOf(&elem).Elem())
+			}
+		} else {
+			value = reflect.AppendSlice(value, reflect.ValueOf(c.value))
+		}
+		c.parent = nil
+	}
+
+	n.children = nil
+	n.nodeType = nt
+	n.isArray = true
+	n.value = value.Interface()
+	return nil
+}
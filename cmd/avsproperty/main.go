@@ -9,9 +9,17 @@ import (
 )
 
 func main() {
-	var unicode bool
+	var (
+		unicode bool
+		check   bool
+		schema  string
+		format  string
+	)
 
 	flag.BoolVar(&unicode, "u", false, "Set output encoding to UTF-8")
+	flag.BoolVar(&check, "check", false, "Validate the file without producing any output")
+	flag.StringVar(&schema, "schema", "", "Validate the file against a JSON `schema`")
+	flag.StringVar(&format, "f", "", "Write the file in the specified `format` (binary, xml, prettyxml, json, prettyjson)")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] FILENAME \n\nProperty format conversion tool\n\nList of available options:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -29,7 +37,35 @@ func main() {
 		os.Exit(1)
 	}
 
-	if prop.Settings.Format == avsproperty.FormatBinary {
+	if err := prop.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if schema != "" {
+		s, err := avsproperty.ReadSchemaFile(schema)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if errs := s.Validate(prop.Root); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+	}
+	if check {
+		return
+	}
+
+	if format != "" {
+		f, err := avsproperty.ParsePropertyFormat(format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		prop.Settings.Format = f
+	} else if prop.Settings.Format == avsproperty.FormatBinary {
 		prop.Settings.Format = avsproperty.FormatPrettyXML
 	} else {
 		prop.Settings.Format = avsproperty.FormatBinary
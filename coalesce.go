@@ -0,0 +1,80 @@
+package avsproperty
+
+import "reflect"
+
+// CoalesceScalars replaces each run of consecutive children of the Node
+// that have the specified name with a single child that holds an array
+// of their values, in the same order. The children must have scalar
+// values of the same type, and must not have attributes. Vectors are
+// not considered to be scalars. If an error is returned, the Node is
+// left unmodified.
+func (n *Node) CoalesceScalars(name string) error {
+	nn, err := searchName(name)
+	if err != nil {
+		return err
+	}
+
+	type run struct {
+		start, end int
+	}
+	var runs []run
+	for i := 0; i < len(n.children); i++ {
+		if !n.children[i].name.Equals(nn) {
+			continue
+		}
+
+		r := run{i, i + 1}
+		for r.end < len(n.children) && n.children[r.end].name.Equals(nn) {
+			r.end++
+		}
+		first := n.children[i]
+		for _, c := range n.children[r.start:r.end] {
+			if err := c.checkScalar(); err != nil {
+				return err
+			}
+			if c.nodeType != first.nodeType {
+				return c.error("cannot coalesce values of type " +
+					c.nodeType.Name() + " and " + first.nodeType.Name())
+			}
+		}
+		runs = append(runs, r)
+		i = r.end - 1
+	}
+
+	children := make([]*Node, 0, len(n.children))
+	last := 0
+	for _, r := range runs {
+		children = append(children, n.children[last:r.start]...)
+
+		first := n.children[r.start]
+		slice := reflect.MakeSlice(reflect.SliceOf(first.nodeType.rt), 0, r.end-r.start)
+		for _, c := range n.children[r.start:r.end] {
+			slice = reflect.Append(slice, reflect.ValueOf(c.value))
+			if c != first {
+				c.parent = nil
+			}
+		}
+		first.value = slice.Interface()
+		first.isArray = true
+
+		children = append(children, first)
+		last = r.end
+	}
+	n.children = append(children, n.children[last:]...)
+
+	return nil
+}
+
+func (n *Node) checkScalar() error {
+	nt := n.nodeType
+	if nt == VoidNode || nt == StrNode || nt == BinNode || nt.count != 1 || n.isArray {
+		return n.error("node does not contain a scalar value")
+	}
+	if n.value == nil || reflect.TypeOf(n.value) != nt.rt {
+		return n.error("node contains an invalid value")
+	}
+	if len(n.attributes) > 0 {
+		return n.error("cannot coalesce node with attributes")
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package avsproperty
+
+// Compact removes void nodes that have neither children nor attributes
+// from the tree. Nodes are removed bottom-up, so nodes whose children
+// were all removed are removed as well. The root node is never removed.
+func (p *Property) Compact() {
+	if p.Root != nil {
+		p.Root.compact(true)
+	}
+}
+
+// CompactAll is like Compact, but also removes void nodes that have
+// attributes.
+func (p *Property) CompactAll() {
+	if p.Root != nil {
+		p.Root.compact(false)
+	}
+}
+
+func (n *Node) compact(keepAttributed bool) {
+	children := n.children[:0]
+	for _, c := range n.children {
+		c.compact(keepAttributed)
+		if c.nodeType == VoidNode && len(c.children) == 0 &&
+			(!keepAttributed || len(c.attributes) == 0) {
+			c.parent = nil
+			continue
+		}
+		children = append(children, c)
+	}
+	for i := len(children); i < len(n.children); i++ {
+		n.children[i] = nil
+	}
+	n.children = children
+}
@@ -0,0 +1,85 @@
+package avsproperty
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Properties are exchanged over connections in frames, each of which
+// contains a single document of any format, prefixed with its size as
+// a big-endian 32-bit integer. Frames are limited to 64 MiB, so that
+// the size read from a connection can't cause large allocations.
+
+const maxFrameSize = 0x4000000
+
+// ReadConn reads a single frame from conn, and returns the Property that
+// it contains. If timeout is greater than 0, the read has to complete
+// within timeout. The read deadline of conn is cleared afterwards.
+func ReadConn(conn net.Conn, timeout time.Duration) (*Property, error) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return readFrame(conn)
+}
+
+// WriteConn writes p to conn as a single frame, using the settings of p.
+// If timeout is greater than 0, the write has to complete within
+// timeout. The write deadline of conn is cleared afterwards.
+func WriteConn(conn net.Conn, p *Property, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	return writeFrame(conn, p)
+}
+
+func readFrame(rd io.Reader) (*Property, error) {
+	var size uint32
+	if err := binary.Read(rd, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxFrameSize {
+		return nil, propertyError("frame is too large: " + strconv.FormatUint(uint64(size), 10) + " bytes")
+	}
+
+	// the buffer grows as the data arrives, so an invalid
+	// size does not cause a large allocation up front
+	buf := &bytes.Buffer{}
+	if _, err := io.CopyN(buf, rd, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	prop := &Property{}
+	if err := prop.Read(buf); err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+func writeFrame(wr io.Writer, p *Property) error {
+	// reserve space for the size
+	buf := bytes.NewBuffer(make([]byte, 4))
+	if err := p.Write(buf); err != nil {
+		return err
+	}
+
+	b := buf.Bytes()
+	if len(b)-4 > maxFrameSize {
+		return propertyError("property is too large for a frame")
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := wr.Write(b)
+	return err
+}
@@ -0,0 +1,87 @@
+package avsproperty
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CheckConsistency checks whether the Go types of the values of all
+// Nodes in the tree match their types, including the elements of array
+// values. Mismatched values, which can only be assigned by modifying
+// the tree incorrectly, would otherwise cause a panic while the
+// Property is written. An error that contains the path of the Node is
+// returned for each mismatch, and nil is returned if there are none.
+// nil values are not reported; refer to Validate.
+func (p *Property) CheckConsistency() []error {
+	if p.Root == nil {
+		return []error{propertyError("property is empty")}
+	}
+
+	var errs []error
+	p.Root.Traverse(func(n *Node) error {
+		if err := n.checkConsistency(); err != nil {
+			errs = append(errs, propertyError(n.Path()+": "+err.Error()))
+		}
+		return nil
+	}, nil)
+	return errs
+}
+
+func (n *Node) checkConsistency() error {
+	if n.value == nil {
+		return nil
+	}
+	if n.nodeType == VoidNode {
+		return fmt.Errorf("void node contains a value of type %T", n.value)
+	}
+	if !n.isArray {
+		if !n.nodeType.matches(n.value) {
+			return fmt.Errorf("value of type %T does not match type %s", n.value, n.nodeType.Name())
+		}
+		return nil
+	}
+
+	if n.nodeType == StrNode || n.nodeType == BinNode {
+		return fmt.Errorf("invalid array type %s", n.nodeType.Name())
+	}
+	rv := reflect.ValueOf(n.value)
+	if rv.Type() == reflect.SliceOf(n.nodeType.rt) {
+		return nil
+	}
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Interface {
+		return fmt.Errorf("array value of type %T does not match type %s", n.value, n.nodeType.Name())
+	}
+	// arrays that were read from a document are stored as []any
+	for i := 0; i < rv.Len(); i++ {
+		if elem := rv.Index(i).Interface(); elem == nil || !n.nodeType.matches(elem) {
+			return fmt.Errorf("array element %d of type %T does not match type %s", i, elem, n.nodeType.Name())
+		}
+	}
+	return nil
+}
+
+// matches reports whether v is a valid scalar value of the type.
+func (t *NodeType) matches(v any) bool {
+	switch v.(type) {
+	case *lazyString:
+		return t == StrNode
+	case *streamedBinary:
+		return t == BinNode
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Type() == t.rt {
+		return true
+	}
+	// vectors that were read from a document are stored as arrays of any
+	if t.rt.Kind() != reflect.Array || rv.Kind() != reflect.Array ||
+		rv.Type().Elem().Kind() != reflect.Interface || rv.Len() != t.rt.Len() {
+		return false
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if elem := rv.Index(i).Elem(); !elem.IsValid() || elem.Type() != t.rt.Elem() {
+			return false
+		}
+	}
+	return true
+}
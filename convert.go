@@ -0,0 +1,146 @@
+package avsproperty
+
+import (
+	"math"
+	"reflect"
+)
+
+// ConvertType changes the Node's type to t, and converts its value to
+// the Go type of t. Integer and floating-point values can be converted
+// to any other integer or floating-point type, as long as the value can
+// be represented exactly by the new type. Vectors can only be converted
+// to vectors with the same number of elements, and array values are
+// converted element-wise. Types that share a Go type, like vb and
+// bitset16, can be converted into each other as well. Values of other
+// types can not be converted.
+// The Node is left unmodified if an error is returned.
+func (n *Node) ConvertType(t *NodeType) error {
+	if n.nodeType == t {
+		return nil
+	}
+	if n.nodeType == VoidNode || t == VoidNode || n.value == nil {
+		return n.error("cannot convert to " + t.Name())
+	}
+	if n.nodeType.count != t.count {
+		return n.error("vector size mismatch")
+	}
+	if n.nodeType.rt == t.rt {
+		n.nodeType = t
+		return nil
+	}
+
+	src, dst := numericType(n.nodeType), numericType(t)
+	if src == nil || dst == nil {
+		return n.error("cannot convert " + n.nodeType.Name() + " to " + t.Name())
+	}
+
+	rv := reflect.ValueOf(n.value)
+	var v reflect.Value
+	if n.isArray {
+		v = reflect.MakeSlice(reflect.SliceOf(t.rt), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := convertValue(v.Index(i), rv.Index(i), t.count > 1); err != nil {
+				return n.error(err.Error())
+			}
+		}
+	} else {
+		v = reflect.New(t.rt).Elem()
+		if err := convertValue(v, rv, t.count > 1); err != nil {
+			return n.error(err.Error())
+		}
+	}
+
+	return n.SetValue(v.Interface())
+}
+
+// numericType returns the Go type of t's elements, or nil
+// if t is not an integer or floating-point type.
+func numericType(t *NodeType) reflect.Type {
+	rt := t.rt
+	if t.count > 1 {
+		rt = rt.Elem()
+	}
+	switch rt.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return rt
+	default:
+		return nil
+	}
+}
+
+func convertValue(dst, src reflect.Value, vector bool) error {
+	if src.Kind() == reflect.Interface {
+		src = src.Elem()
+	}
+
+	if vector {
+		for i := 0; i < dst.Len(); i++ {
+			if err := convertValue(dst.Index(i), src.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var ok bool
+	switch src.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ok = convertInt(dst, src.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		ok = convertUint(dst, src.Uint())
+	default:
+		ok = convertFloat(dst, src.Float())
+	}
+	if !ok {
+		return propertyError("value cannot be represented by the new type")
+	}
+	return nil
+}
+
+func convertInt(dst reflect.Value, i int64) bool {
+	switch dst.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		if dst.OverflowInt(i) {
+			return false
+		}
+		dst.SetInt(i)
+		return true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return i >= 0 && convertUint(dst, uint64(i))
+	default:
+		dst.SetFloat(float64(i))
+		f := dst.Float()
+		return f >= -(1<<63) && f < 1<<63 && int64(f) == i
+	}
+}
+
+func convertUint(dst reflect.Value, u uint64) bool {
+	switch dst.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		return u <= math.MaxInt64 && convertInt(dst, int64(u))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		if dst.OverflowUint(u) {
+			return false
+		}
+		dst.SetUint(u)
+		return true
+	default:
+		dst.SetFloat(float64(u))
+		f := dst.Float()
+		return f < 1<<64 && uint64(f) == u
+	}
+}
+
+func convertFloat(dst reflect.Value, f float64) bool {
+	switch dst.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		return math.Trunc(f) == f && f >= -(1<<63) && f < 1<<63 && convertInt(dst, int64(f))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return math.Trunc(f) == f && f >= 0 && f < 1<<64 && convertUint(dst, uint64(f))
+	default:
+		dst.SetFloat(f)
+		return dst.Float() == f || math.IsNaN(f)
+	}
+}
@@ -0,0 +1,69 @@
+package avsproperty
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Dump returns a human-readable representation of the tree at the Node,
+// which is intended for debugging purposes. Refer to DumpTo for details.
+func (n *Node) Dump() string {
+	sb := &strings.Builder{}
+	n.DumpTo(sb)
+	return sb.String()
+}
+
+// DumpTo writes a human-readable representation of the tree at the Node
+// to the Writer. Each node is written on its own line that contains its
+// name, type, attributes, and value, and is indented according to its
+// depth. The output is intended for debugging purposes, and cannot be
+// read back.
+func (n *Node) DumpTo(wr io.Writer) error {
+	depth := 0
+	return n.Traverse(func(node *Node) error {
+		line := strings.Repeat("  ", depth) + node.dumpLine() + "\n"
+		depth++
+		_, err := io.WriteString(wr, line)
+		return err
+	}, func(*Node) error {
+		depth--
+		return nil
+	})
+}
+
+func (n *Node) dumpLine() string {
+	sb := &strings.Builder{}
+	sb.WriteString(n.name.String())
+	sb.WriteString(" [")
+	sb.WriteString(n.nodeType.Name())
+	if n.isArray {
+		sb.WriteByte('[')
+		sb.WriteString(strconv.Itoa(n.ArrayLength()))
+		sb.WriteByte(']')
+	}
+	sb.WriteByte(']')
+
+	for _, attrib := range n.attributes {
+		sb.WriteByte(' ')
+		sb.WriteString(attrib.key.String())
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(attrib.Value))
+	}
+
+	if n.nodeType != VoidNode {
+		sb.WriteString(" = ")
+		switch v := n.resolvedValue().(type) {
+		case string:
+			sb.WriteString(strconv.Quote(v))
+		case BinValue:
+			sb.WriteString(hex.EncodeToString(v))
+		default:
+			fmt.Fprint(sb, v)
+		}
+	}
+
+	return sb.String()
+}
@@ -1,11 +1,17 @@
 package avsproperty
 
 import (
+	"bytes"
+	"io"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/transform"
 )
 
 type Encoding struct {
@@ -22,6 +28,187 @@ func (e *Encoding) String() string {
 	return e.name
 }
 
+// EncodeErrorPolicy defines how characters that cannot be represented
+// by the encoding of a Property are handled when it's written.
+type EncodeErrorPolicy int
+
+const (
+	// EncodeErrorFail causes the write operation to fail.
+	EncodeErrorFail EncodeErrorPolicy = iota
+	// EncodeErrorReplace replaces each character with a '?'.
+	EncodeErrorReplace
+	// EncodeErrorDrop omits the characters from the output.
+	EncodeErrorDrop
+)
+
+// stringEncoder encodes strings using an encoding,
+// and applies an EncodeErrorPolicy.
+type stringEncoder struct {
+	encoding *Encoding
+	encoder  *encoding.Encoder
+	policy   EncodeErrorPolicy
+	ascii    bool
+}
+
+func newStringEncoder(e *Encoding, settings *PropertySettings) *stringEncoder {
+	return &stringEncoder{
+		encoding: e,
+		encoder:  e.encoder(),
+		policy:   settings.EncodeErrorPolicy,
+		ascii:    e == EncodingASCII && !settings.LenientASCII,
+	}
+}
+
+func (se *stringEncoder) encode(s string) ([]byte, error) {
+	if se.ascii {
+		if isASCII(s) {
+			return []byte(s), nil
+		}
+	} else if se.encoder == nil {
+		return []byte(s), nil
+	} else if b, err := se.encoder.Bytes([]byte(s)); err == nil {
+		return b, nil
+	}
+
+	// encode each rune separately to find the ones
+	// that can't be represented
+	b := make([]byte, 0, len(s))
+	for i, r := range s {
+		if encoded, ok := se.encodeRune(r); ok {
+			b = append(b, encoded...)
+			continue
+		}
+
+		switch se.policy {
+		case EncodeErrorReplace:
+			b = append(b, '?')
+		case EncodeErrorDrop:
+		default:
+			return nil, propertyError("cannot encode character " + strconv.QuoteRune(r) +
+				" at offset " + strconv.Itoa(i) + " of " + strconv.Quote(s) + " as " + se.encoding.String())
+		}
+	}
+	return b, nil
+}
+
+func (se *stringEncoder) encodeRune(r rune) ([]byte, bool) {
+	if se.ascii {
+		return []byte{byte(r)}, r < utf8.RuneSelf
+	}
+	b, err := se.encoder.Bytes(utf8.AppendRune(nil, r))
+	return b, err == nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// utf8Sanitizer replaces invalid UTF-8 sequences in the bytes that are
+// read from rd with U+FFFD. Bytes are read one at a time, so that rd
+// can be handed to a decoder once the encoding of a document is known.
+type utf8Sanitizer struct {
+	rd      io.Reader
+	pending []byte
+}
+
+func (s *utf8Sanitizer) ReadByte() (byte, error) {
+	if len(s.pending) == 0 {
+		scan := s.rd.(io.ByteScanner)
+		c, err := scan.ReadByte()
+		if err != nil || c < utf8.RuneSelf {
+			return c, err
+		}
+
+		s.pending = append(s.pending[:0], c)
+		for !utf8.FullRune(s.pending) {
+			c, err := scan.ReadByte()
+			if err != nil {
+				break
+			}
+			if utf8.RuneStart(c) {
+				scan.UnreadByte()
+				break
+			}
+			s.pending = append(s.pending, c)
+		}
+		if r, _ := utf8.DecodeRune(s.pending); r == utf8.RuneError {
+			s.pending = utf8.AppendRune(s.pending[:0], utf8.RuneError)
+		}
+	}
+
+	c := s.pending[0]
+	s.pending = s.pending[1:]
+	return c, nil
+}
+
+func (s *utf8Sanitizer) Read(b []byte) (int, error) {
+	for i := range b {
+		c, err := s.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		b[i] = c
+	}
+	return len(b), nil
+}
+
+// sanitizeUTF8 replaces invalid UTF-8 sequences in b with U+FFFD, in
+// the same way as utf8Sanitizer.
+func sanitizeUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	out, _ := io.ReadAll(&utf8Sanitizer{rd: bytes.NewReader(b)})
+	return string(out)
+}
+
+// decodeString decodes b, which is encoded using e, with decoder, which
+// is nil if e has no charset. If lenient is set, invalid sequences are
+// replaced with U+FFFD instead of causing an error. A decoder that
+// fails is restarted after the byte it failed at, since its sequences
+// can't be told apart.
+func decodeString(b []byte, e *Encoding, decoder *encoding.Decoder, lenient bool) (string, error) {
+	if decoder == nil {
+		if lenient && e != EncodingNone {
+			return sanitizeUTF8(b), nil
+		}
+		return string(b), nil
+	}
+
+	decoded, err := decoder.Bytes(b)
+	if err == nil {
+		return string(decoded), nil
+	}
+	if !lenient {
+		return "", err
+	}
+
+	out := make([]byte, 0, len(b))
+	dst := make([]byte, 2*len(b)+utf8.UTFMax)
+	for len(b) > 0 {
+		decoder.Reset()
+		nDst, nSrc, err := decoder.Transform(dst, b, true)
+		out = append(out, dst[:nDst]...)
+		b = b[nSrc:]
+		switch err {
+		case nil:
+		case transform.ErrShortDst:
+			dst = make([]byte, 2*len(dst))
+		default:
+			out = utf8.AppendRune(out, utf8.RuneError)
+			if len(b) > 0 {
+				b = b[1:]
+			}
+		}
+	}
+	return string(out), nil
+}
+
 func (e *Encoding) encoder() *encoding.Encoder {
 	if e.charset == nil {
 		return nil
@@ -66,6 +253,11 @@ var (
 		name:     "UTF-8",
 		charset:  nil,
 	}
+	EncodingEUCKR = &Encoding{
+		codepage: 6,
+		name:     "EUC-KR",
+		charset:  korean.EUCKR,
+	}
 
 	// order matters!
 	encodingLut = []*Encoding{
@@ -75,6 +267,7 @@ var (
 		EncodingEUCJP,
 		EncodingSJIS,
 		EncodingUTF8,
+		EncodingEUCKR,
 	}
 )
 
@@ -112,14 +305,87 @@ func EncodingByName(name string) *Encoding {
 	case "UTF8":
 		return EncodingUTF8
 
+	case "EUC-KR":
+		fallthrough
+	case "EUCKR":
+		fallthrough
+	case "KS_C_5601":
+		return EncodingEUCKR
+
 	default:
 		return nil
 	}
 }
 
+func (e *Encoding) valid() bool {
+	return encodingById(byte(e.codepage)) == e
+}
+
 func encodingById(id byte) *Encoding {
 	if int(id) >= len(encodingLut) {
 		return nil
 	}
 	return encodingLut[id]
 }
+
+// NormalizeEncoding changes the encoding of the Property to UTF-8, after
+// making sure that the values of all str nodes and attributes are valid
+// UTF-8. If the encoding of the Property is EncodingNone and source is not
+// nil, the strings are assumed to be undecoded, and are decoded using
+// source first. If any string is invalid, an error that lists their
+// locations is returned, and the Property is left unmodified.
+func (p *Property) NormalizeEncoding(source *Encoding) error {
+	if p.Root == nil {
+		return propertyError("property is empty")
+	}
+
+	var decoder *encoding.Decoder
+	if p.Encoding() == EncodingNone && source != nil {
+		decoder = source.decoder()
+	}
+	normalize := func(s string) (string, bool) {
+		if decoder != nil {
+			b, err := decoder.Bytes([]byte(s))
+			if err != nil {
+				return "", false
+			}
+			s = string(b)
+		}
+		return s, utf8.ValidString(s)
+	}
+
+	var (
+		invalid []string
+		values  = make(map[*Node]string)
+		attribs = make(map[*Attribute]string)
+	)
+	p.Root.Traverse(func(n *Node) error {
+		if n.nodeType == StrNode && n.value != nil {
+			if s, ok := normalize(n.StringValue()); ok {
+				values[n] = s
+			} else {
+				invalid = append(invalid, n.Path())
+			}
+		}
+		for _, attrib := range n.attributes {
+			if s, ok := normalize(attrib.Value); ok {
+				attribs[attrib] = s
+			} else {
+				invalid = append(invalid, n.Path()+"@"+attrib.key.String())
+			}
+		}
+		return nil
+	}, nil)
+	if len(invalid) > 0 {
+		return propertyError("invalid UTF-8 in " + strings.Join(invalid, ", "))
+	}
+
+	for n, s := range values {
+		n.value = s
+	}
+	for attrib, s := range attribs {
+		attrib.Value = s
+	}
+	p.Settings.Encoding = EncodingUTF8
+	return nil
+}
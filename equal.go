@@ -0,0 +1,142 @@
+package avsproperty
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// EqualApprox reports whether the trees at n and other are equal, where
+// float and double values are considered equal if they differ by at most
+// epsilon. All other values, as well as names, types, and attributes,
+// have to be equal exactly. Values that were read from a document are
+// considered equal to values of the corresponding Go types.
+func (n *Node) EqualApprox(other *Node, epsilon float64) bool {
+	return n.equal(other, &equalOptions{epsilon: epsilon}, "")
+}
+
+// Equals reports whether the trees at n and other are structurally equal.
+// Names, types, values, and children have to be equal, and in the same
+// order, while attributes are compared by key regardless of their order.
+// Two NaN values are considered equal, and so are nil and empty bin
+// values. Values that were read from a document are considered equal
+// to values of the corresponding Go types.
+func (n *Node) Equals(other *Node) bool {
+	return n.equal(other, &equalOptions{unorderedAttribs: true}, "")
+}
+
+// EqualExcept is like Equals, but the values of the Nodes whose paths
+// match any of ignorePaths are not compared, e.g. to ignore timestamps.
+// The paths start with the name of n, like the result of Node.Path for
+// a root Node, and may end with a '*' to match any path that begins
+// with the rest of the pattern.
+func (n *Node) EqualExcept(other *Node, ignorePaths []string) bool {
+	opts := &equalOptions{unorderedAttribs: true, ignorePaths: ignorePaths}
+	if n == nil {
+		return n.equal(other, opts, "")
+	}
+	return n.equal(other, opts, n.name.String())
+}
+
+type equalOptions struct {
+	epsilon          float64
+	unorderedAttribs bool
+	ignorePaths      []string
+}
+
+// ignored reports whether the value at path is not compared.
+func (opts *equalOptions) ignored(path string) bool {
+	for _, pattern := range opts.ignorePaths {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) equal(other *Node, opts *equalOptions, path string) bool {
+	if n == other {
+		return true
+	}
+	if n == nil || other == nil {
+		return false
+	}
+
+	if !n.name.Equals(other.name) || n.nodeType != other.nodeType || n.isArray != other.isArray ||
+		len(n.attributes) != len(other.attributes) || len(n.children) != len(other.children) {
+		return false
+	}
+	for i, a := range n.attributes {
+		b := other.attributes[i]
+		if opts.unorderedAttribs {
+			b = other.SearchAttributeNodeName(a.key)
+		}
+		if b == nil || !a.key.Equals(b.key) || a.Value != b.Value {
+			return false
+		}
+	}
+
+	if n.nodeType != VoidNode && (len(opts.ignorePaths) == 0 || !opts.ignored(path)) {
+		a, b := n.resolvedValue(), other.resolvedValue()
+		if (a == nil) != (b == nil) {
+			return false
+		}
+		if a != nil && !valuesEqual(reflect.ValueOf(a), reflect.ValueOf(b), opts.epsilon) {
+			return false
+		}
+	}
+
+	for i, c := range n.children {
+		var childPath string
+		if len(opts.ignorePaths) > 0 {
+			childPath = path + "/" + c.name.String()
+		}
+		if !c.equal(other.children[i], opts, childPath) {
+			return false
+		}
+	}
+	return true
+}
+
+func valuesEqual(a, b reflect.Value, epsilon float64) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+
+	// binary documents store IPv4 addresses in their 16-byte form
+	if a.Type() == IPv4Node.rt && b.Type() == IPv4Node.rt {
+		return a.Interface().(net.IP).Equal(b.Interface().(net.IP))
+	}
+
+	if kind := a.Kind(); kind == reflect.Slice || kind == reflect.Array {
+		if kind := b.Kind(); (kind != reflect.Slice && kind != reflect.Array) || a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !valuesEqual(a.Index(i), b.Index(i), epsilon) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+	if kind := a.Kind(); kind == reflect.Float32 || kind == reflect.Float64 {
+		x, y := a.Float(), b.Float()
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return math.IsNaN(x) && math.IsNaN(y)
+		}
+		return x == y || math.Abs(x-y) <= epsilon
+	}
+	return a.Interface() == b.Interface()
+}
@@ -0,0 +1,73 @@
+package avsproperty
+
+import (
+	"reflect"
+	"sync"
+)
+
+type valueFormatter struct {
+	format func(any) string
+	parse  func(string) (any, error)
+}
+
+var (
+	formatters   = map[*NodeType]valueFormatter{}
+	formattersMu sync.RWMutex
+)
+
+// RegisterValueFormatter registers functions that replace the textual
+// representation of values of type t in the XML formats. format converts
+// a value to its textual representation, and parse converts it back.
+// For array values, the functions are called for each element. Since
+// array elements are separated by spaces, the textual representation
+// of a scalar value must not contain any spaces, and the representation
+// of a vector must consist of one space-separated part per element.
+// The values returned by parse must be of t's Go type.
+//
+// Passing nil for both functions removes the registered formatter. The
+// binary format is not affected. RegisterValueFormatter panics if t
+// is void, str, or bin.
+func RegisterValueFormatter(t *NodeType, format func(any) string, parse func(string) (any, error)) {
+	if t == VoidNode || t == StrNode || t == BinNode {
+		panic("avsproperty: cannot register formatter for " + t.Name())
+	}
+
+	formattersMu.Lock()
+	defer formattersMu.Unlock()
+
+	if format == nil && parse == nil {
+		delete(formatters, t)
+		return
+	}
+	if format == nil || parse == nil {
+		panic("avsproperty: incomplete formatter")
+	}
+	formatters[t] = valueFormatter{format, parse}
+}
+
+func lookupFormatter(t *NodeType) (valueFormatter, bool) {
+	formattersMu.RLock()
+	defer formattersMu.RUnlock()
+
+	f, ok := formatters[t]
+	return f, ok
+}
+
+// stringToValueFunc returns the function that is used to parse
+// values of type t.
+func stringToValueFunc(t *NodeType) stringToValue {
+	f, ok := lookupFormatter(t)
+	if !ok {
+		return t.stv
+	}
+	return func(s string) (any, error) {
+		v, err := f.parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.TypeOf(v) != t.rt {
+			return nil, propertyError("formatter returned a value of an invalid type")
+		}
+		return v, nil
+	}
+}
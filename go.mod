@@ -1,5 +1,5 @@
 module github.com/YoshihikoAbe/avsproperty
 
-go 1.21.1
+go 1.23
 
 require golang.org/x/text v0.16.0
@@ -0,0 +1,70 @@
+package avsproperty
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"io"
+	"strconv"
+)
+
+// StableID returns an identifier that is derived from the Node's type and
+// its location within its tree. The location consists of the names of the
+// Node and its ancestors, and the index of each of them among its
+// siblings with the same name. Adding or removing siblings with different
+// names does not change the identifier, so it can be used to track a node
+// across revisions of a document.
+func (n *Node) StableID() string {
+	var path []*Node
+	for c := n; c != nil; c = c.parent {
+		path = append(path, c)
+	}
+
+	h := fnv.New64a()
+	for i := len(path) - 1; i >= 0; i-- {
+		c := path[i]
+		io.WriteString(h, c.name.String())
+		io.WriteString(h, "["+strconv.Itoa(c.sameNameIndex())+"]/")
+	}
+	io.WriteString(h, n.nodeType.Name())
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// errStop stops a traversal early.
+var errStop = propertyError("traversal stopped")
+
+// NodeByStableID returns the Node in the Property's tree whose StableID
+// is id, or nil if no Node matches.
+func (p *Property) NodeByStableID(id string) *Node {
+	if p.Root == nil {
+		return nil
+	}
+
+	var found *Node
+	p.Root.Traverse(func(n *Node) error {
+		if n.StableID() == id {
+			found = n
+			return errStop
+		}
+		return nil
+	}, nil)
+	return found
+}
+
+// sameNameIndex returns the number of the Node's preceding siblings that
+// have the same name.
+func (n *Node) sameNameIndex() int {
+	if n.parent == nil {
+		return 0
+	}
+	var i int
+	for _, c := range n.parent.children {
+		if c == n {
+			break
+		}
+		if c.name.Equals(n.name) {
+			i++
+		}
+	}
+	return i
+}
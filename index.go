@@ -0,0 +1,62 @@
+package avsproperty
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Index maps the paths of the Nodes of a tree to the Nodes, which allows
+// them to be looked up in constant time. An Index is a snapshot of the
+// tree at the time it was built, so it has to be rebuilt after the tree
+// is modified.
+type Index struct {
+	nodes map[string]*Node
+}
+
+// BuildIndex builds an Index of the Property's tree. The paths start with
+// the name of the root node, like the ones accepted by SelectPath. A
+// segment without an index refers to the first node with the name, and
+// subsequent nodes with the same name can be referred to by appending
+// their index among them, e.g. "root/entry[1]" for the second entry.
+func (p *Property) BuildIndex() *Index {
+	index := &Index{
+		nodes: make(map[string]*Node),
+	}
+	if p.Root != nil {
+		index.add(p.Root.name.String(), p.Root)
+	}
+	return index
+}
+
+func (index *Index) add(path string, n *Node) {
+	index.nodes[path] = n
+
+	counts := make(map[string]int)
+	for _, c := range n.children {
+		name := c.name.String()
+		i := counts[name]
+		counts[name]++
+
+		if i > 0 {
+			name += "[" + strconv.Itoa(i) + "]"
+		}
+		index.add(path+"/"+name, c)
+	}
+}
+
+// Get returns the Node at the specified path, or nil if the Index does
+// not contain it. A leading slash is ignored, and an index of 0 may be
+// used to refer to the first node with a name explicitly.
+func (index *Index) Get(path string) *Node {
+	path = strings.TrimPrefix(path, "/")
+	if strings.Contains(path, "[0]") {
+		// names cannot contain brackets
+		path = strings.ReplaceAll(path, "[0]", "")
+	}
+	return index.nodes[path]
+}
+
+// Len returns the number of Nodes in the Index.
+func (index *Index) Len() int {
+	return len(index.nodes)
+}
@@ -0,0 +1,340 @@
+package avsproperty
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type jsonNode struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"__type,omitempty"`
+	Count      *int              `json:"__count,omitempty"`
+	Value      any               `json:"value,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Children   []*jsonNode       `json:"children,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. The Node is
+// encoded as an object containing its name, __type, value, attributes,
+// and children. The number of elements of array values is stored as
+// __count, like in XML documents. Array and vector
+// values are encoded as JSON arrays, binary values as hex strings, and
+// ip4 values as dotted strings. The type and value of void nodes are
+// omitted.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	jn, err := n.toJSONNode(false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jn)
+}
+
+// toJSONNode builds the representation of the tree at n that is encoded
+// by MarshalJSON. If allowNil is true, typed nodes with a nil value are
+// represented without a value, and with a count of 0 if they're arrays.
+func (n *Node) toJSONNode(allowNil bool) (*jsonNode, error) {
+	jn := &jsonNode{Name: n.name.String()}
+
+	if n.nodeType != VoidNode {
+		jn.Type = n.nodeType.Name()
+		if n.value != nil {
+			jn.Value = jsonValue(reflect.ValueOf(n.resolvedValue()))
+		} else if !allowNil {
+			return nil, n.error("node contains a nil value")
+		}
+		if n.isArray {
+			count := 0
+			if n.value != nil {
+				count = n.ArrayLength()
+			}
+			jn.Count = &count
+		}
+	}
+
+	if len(n.attributes) > 0 {
+		jn.Attributes = make(map[string]string, len(n.attributes))
+		for _, attrib := range n.attributes {
+			jn.Attributes[attrib.key.String()] = attrib.Value
+		}
+	}
+
+	for _, c := range n.children {
+		child, err := c.toJSONNode(allowNil)
+		if err != nil {
+			return nil, err
+		}
+		jn.Children = append(jn.Children, child)
+	}
+	return jn, nil
+}
+
+// writeJSON writes the root Node of prop as a JSON document. Since JSON
+// documents are always encoded as UTF-8, the encoding of prop is ignored.
+func writeJSON(prop *Property, wr io.Writer) error {
+	jn, err := prop.Root.toJSONNode(prop.Settings.AllowNilValues)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(wr)
+	if prop.Settings.Format == FormatPrettyJSON {
+		encoder.SetIndent("", "    ")
+	}
+	return encoder.Encode(jn)
+}
+
+// jsonInputNode is the representation of a Node that is produced by
+// MarshalJSON, as it's decoded by readJSON
+type jsonInputNode struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"__type"`
+	Count      *int              `json:"__count"`
+	Value      json.RawMessage   `json:"value"`
+	Attributes map[string]string `json:"attributes"`
+	Children   []*jsonInputNode  `json:"children"`
+}
+
+// readJSON reads a JSON document that was written by writeJSON. Since
+// the attributes of a node are encoded as an object, their order is not
+// preserved, and they are sorted by key instead.
+func readJSON(prop *Property, rd io.Reader, recycler *nodeRecycler) error {
+	prop.Settings.Format = FormatJSON
+	prop.Settings.Encoding = EncodingUTF8
+
+	// the format is detected by a leading '{' or '[', but only
+	// objects can represent a node
+	if c, _ := rd.(io.ByteScanner).ReadByte(); c != '{' {
+		return propertyError("JSON document is not an object")
+	}
+	rd.(io.ByteScanner).UnreadByte()
+
+	var root jsonInputNode
+	if err := json.NewDecoder(rd).Decode(&root); err != nil {
+		return err
+	}
+	state := &jsonReadState{
+		prop:     prop,
+		recycler: recycler,
+	}
+	return state.readNode(nil, &root, 1)
+}
+
+type jsonReadState struct {
+	prop     *Property
+	recycler *nodeRecycler
+}
+
+func (state *jsonReadState) readNode(parent *Node, jn *jsonInputNode, depth int) error {
+	if depth > state.prop.maxDepth() {
+		return propertyError("max depth exceeded")
+	}
+
+	name, err := NewNodeName(jn.Name)
+	if err != nil {
+		return err
+	}
+	node := state.recycler.next(parent, name, nil, false)
+	if node == nil {
+		node = &Node{
+			name:     name,
+			nodeType: VoidNode,
+		}
+	}
+	defer state.recycler.leave()
+
+	if parent == nil {
+		state.prop.Root = node
+	} else if err := parent.AppendChild(node); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(jn.Attributes))
+	for k := range jn.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := node.SetAttribute(k, jn.Attributes[k]); err != nil {
+			return err
+		}
+	}
+
+	if jn.Type != "" && jn.Type != VoidNode.Name() {
+		if err := state.readValue(node, jn); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range jn.Children {
+		if err := state.readNode(node, c, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (state *jsonReadState) readValue(node *Node, jn *jsonInputNode) error {
+	nt := lookupTypeByName(jn.Type)
+	if nt == nil {
+		return node.error("invalid node type: " + jn.Type)
+	}
+	if len(jn.Children) > 0 {
+		return node.error("typed node has children")
+	}
+
+	if jn.Value == nil {
+		if !state.prop.Settings.AllowNilValues {
+			return node.error("node does not have a value")
+		}
+		// nodes that were written without a value get a zero value,
+		// like empty elements in XML documents
+		var v any
+		if jn.Count != nil {
+			if *jn.Count != 0 {
+				return node.error("invalid number of elements in value: expected " +
+					strconv.Itoa(*jn.Count) + ", got 0")
+			}
+			v = reflect.MakeSlice(reflect.SliceOf(nt.rt), 0, 0).Interface()
+		} else {
+			v = nt.ZeroValue()
+		}
+		if err := node.SetValue(v); err != nil {
+			return err
+		}
+		node.nodeType = nt
+		return nil
+	}
+	if err := node.SetValueJSON(nt, jn.Value); err != nil {
+		return node.valueError(err)
+	}
+	// __count is optional, since array values can also be told
+	// apart by their representation
+	if jn.Count != nil && (!node.isArray || node.ArrayLength() != *jn.Count) {
+		return node.error("invalid number of elements in value: expected " +
+			strconv.Itoa(*jn.Count) + ", got " + strconv.Itoa(node.ArrayLength()))
+	}
+	// types that share their Go type with another type
+	// are not restored by SetValue
+	node.nodeType = nt
+	return nil
+}
+
+func jsonValue(rv reflect.Value) any {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch v := rv.Interface().(type) {
+	case net.IP:
+		return v.String()
+	case BinValue:
+		return hex.EncodeToString(v)
+	}
+
+	if kind := rv.Kind(); kind == reflect.Slice || kind == reflect.Array {
+		slice := make([]any, rv.Len())
+		for i := range slice {
+			slice[i] = jsonValue(rv.Index(i))
+		}
+		return slice
+	}
+	return rv.Interface()
+}
+
+// SetValueJSON decodes raw as a value of type t, and sets the Node's
+// value to it. A JSON array of values is decoded as an array value, and
+// vectors are represented as JSON arrays. Binary values are decoded
+// from hex or base64 strings, and ip4 values from dotted strings.
+func (n *Node) SetValueJSON(t *NodeType, raw json.RawMessage) error {
+	if t == VoidNode {
+		return n.error("cannot assign value of type void")
+	}
+
+	var (
+		v   any
+		err error
+	)
+	if elems, ok := jsonArray(t, raw); ok {
+		slice := reflect.MakeSlice(reflect.SliceOf(t.rt), len(elems), len(elems))
+		for i, elem := range elems {
+			ev, err := jsonToValue(t, elem)
+			if err != nil {
+				return err
+			}
+			slice.Index(i).Set(reflect.ValueOf(ev))
+		}
+		v = slice.Interface()
+	} else if v, err = jsonToValue(t, raw); err != nil {
+		return err
+	}
+
+	return n.SetValue(v)
+}
+
+// jsonArray reports whether raw represents an array value of type t,
+// in which case its elements are returned.
+func jsonArray(t *NodeType, raw json.RawMessage) ([]json.RawMessage, bool) {
+	if t == StrNode || t == BinNode || !isJSONArray(raw) {
+		return nil, false
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		return nil, false
+	}
+	// vectors are arrays as well, so an array value of
+	// vectors must consist of nested arrays
+	if t.count > 1 && len(elems) > 0 && !isJSONArray(elems[0]) {
+		return nil, false
+	}
+	return elems, true
+}
+
+func isJSONArray(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && raw[0] == '['
+}
+
+func jsonToValue(t *NodeType, raw json.RawMessage) (any, error) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, propertyError("value is null")
+	}
+
+	if t == BinNode {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		if b, err := hex.DecodeString(s); err == nil {
+			return BinValue(b), nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, propertyError("binary value is neither hex nor base64")
+		}
+		return BinValue(b), nil
+	}
+
+	if t.count > 1 {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, err
+		}
+		if len(elems) != t.count {
+			return nil, propertyError("vector contains an invalid number of elements")
+		}
+	}
+
+	v := reflect.New(t.rt)
+	if err := json.Unmarshal(raw, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
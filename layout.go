@@ -0,0 +1,94 @@
+package avsproperty
+
+// LeafLayout describes the location of a value in the databody of a
+// binary document.
+type LeafLayout struct {
+	// Path is the slash-delimited path of the node the value belongs
+	// to. For attributes, the key is appended after an '@'.
+	Path string
+	// Offset is the offset of the value from the start of the databody,
+	// excluding its size field.
+	Offset int
+	// Length is the number of bytes that the value occupies, including
+	// its size field and padding, if it has any.
+	Length int
+	// Bytes contains the bytes that the value occupies.
+	Bytes []byte
+}
+
+// DatabodyLayout returns the locations of the values of the Property's
+// nodes and attributes in the databody of its binary representation,
+// in the order in which they are written.
+func (p *Property) DatabodyLayout() ([]LeafLayout, error) {
+	if p.Root == nil {
+		return nil, propertyError("property is empty")
+	}
+
+	state := binaryWriteState{
+		prop:    p,
+		encoder: newStringEncoder(p.Encoding(), &p.Settings),
+		order:   p.byteOrder(),
+	}
+	if p.Settings.DedupeStrings {
+		state.strings = make(map[string]uint32)
+	}
+
+	var layout []LeafLayout
+	record := func(path string, start int, write func() error) error {
+		if err := write(); err != nil {
+			return err
+		}
+		layout = append(layout, LeafLayout{
+			Path:   path,
+			Offset: start,
+			Length: len(state.databody) - start,
+		})
+		return nil
+	}
+
+	err := p.Root.Traverse(func(n *Node) error {
+		if n.nodeType != VoidNode {
+			if n.value == nil {
+				return n.error("node contains a nil value")
+			}
+
+			if size := n.nodeType.size; n.isPacked() {
+				// packed values do not necessarily extend the databody
+				if err := state.writeValue(n); err != nil {
+					return err
+				}
+				i := state.i8
+				if size == 2 {
+					i = state.i16
+				}
+				layout = append(layout, LeafLayout{
+					Path:   n.Path(),
+					Offset: i - size,
+					Length: size,
+				})
+			} else if err := record(n.Path(), len(state.databody), func() error {
+				return state.writeValue(n)
+			}); err != nil {
+				return err
+			}
+		}
+
+		for _, attrib := range n.attributes {
+			if err := record(n.Path()+"@"+attrib.key.String(), len(state.databody), func() error {
+				return state.writeString(attrib.Value)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range layout {
+		l := &layout[i]
+		l.Bytes = append([]byte{}, state.databody[l.Offset:l.Offset+l.Length]...)
+	}
+	return layout, nil
+}
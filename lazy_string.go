@@ -0,0 +1,32 @@
+package avsproperty
+
+// lazyString holds the undecoded value of a str node that was read with
+// PropertySettings.LazyStrings enabled.
+type lazyString struct {
+	raw      []byte
+	encoding *Encoding
+	lenient  bool
+}
+
+func (ls *lazyString) decode() string {
+	s, err := decodeString(ls.raw, ls.encoding, ls.encoding.decoder(), ls.lenient)
+	if err != nil {
+		return string(ls.raw)
+	}
+	return s
+}
+
+// resolvedValue returns the Node's value after decoding it, if it's
+// a lazily decoded string, or loading it, if it's a streamed bin value.
+// The result replaces the value. Streamed values that cannot be loaded
+// are returned as empty bin values.
+func (n *Node) resolvedValue() any {
+	switch v := n.value.(type) {
+	case *lazyString:
+		n.value = v.decode()
+	case *streamedBinary:
+		b, _ := n.loadBinary()
+		return b
+	}
+	return n.value
+}
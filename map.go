@@ -0,0 +1,91 @@
+package avsproperty
+
+import "sort"
+
+// SetFromMap creates a child for each key in m, and appends them to the
+// Node in the order of their keys. Values are assigned using SetValue,
+// except for values of type map[string]any, which are recursively
+// converted into void nodes with children. If an error is returned,
+// the Node is left unmodified.
+func (n *Node) SetFromMap(m map[string]any) error {
+	children, err := nodesFromMap(m)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := n.AppendChild(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nodesFromMap(m map[string]any) ([]*Node, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	children := make([]*Node, len(keys))
+	for i, k := range keys {
+		c, err := NewNode(k)
+		if err != nil {
+			return nil, propertyError("invalid key: " + k)
+		}
+
+		if v, ok := m[k].(map[string]any); ok {
+			err = c.SetFromMap(v)
+		} else {
+			err = c.SetValue(m[k])
+		}
+		if err != nil {
+			return nil, err
+		}
+		children[i] = c
+	}
+	return children, nil
+}
+
+// ToMap returns the Node's children as a map, which is the reverse of
+// SetFromMap. Void children are converted into nested maps, and the
+// values of other children are stored as they are. If multiple children
+// share a name, their values are stored in a []any under that name, in
+// order. The attributes of the Node, and of its void descendants, are
+// stored under their key prefixed with "@", which cannot collide with
+// node names. The attributes of typed children are omitted.
+func (n *Node) ToMap() map[string]any {
+	m := make(map[string]any, len(n.children)+len(n.attributes))
+	for _, a := range n.attributes {
+		m["@"+a.key.String()] = a.Value
+	}
+
+	for _, c := range n.children {
+		var v any
+		if c.nodeType == VoidNode {
+			v = c.ToMap()
+		} else {
+			v = c.Value()
+		}
+
+		k := c.name.String()
+		if existing, ok := m[k]; !ok {
+			m[k] = v
+		} else if r, ok := existing.(repeated); ok {
+			m[k] = append(r, v)
+		} else {
+			m[k] = repeated{existing, v}
+		}
+	}
+
+	for k, v := range m {
+		if v, ok := v.(repeated); ok {
+			m[k] = []any(v)
+		}
+	}
+	return m
+}
+
+// repeated distinguishes the values of children that share a name
+// from []any values while a map is being built
+type repeated []any
@@ -70,6 +70,10 @@ func (n *NodeName) Set(s string) error {
 	return nil
 }
 
+func (n *NodeName) valid() bool {
+	return n != nil && n.length > 0 && n.length <= nodeNameSize
+}
+
 func (n *NodeName) Length() int {
 	return n.length
 }
@@ -155,6 +159,19 @@ func (n *NodeName) writeBinary(wr io.Writer, long bool) (err error) {
 	return err
 }
 
+// PackedSize returns the number of bytes that the name occupies in the
+// metadata of a binary document, where each character is packed into
+// 6 bits. The byte that holds the length of the name is not included.
+func (n *NodeName) PackedSize() int {
+	return n.binarySize(false)
+}
+
+// BinarySize behaves like PackedSize, but returns the size of the name
+// in documents that use long node names if long is true.
+func (n *NodeName) BinarySize(long bool) int {
+	return n.binarySize(long)
+}
+
 func (n *NodeName) binarySize(long bool) int {
 	if long {
 		return n.length
@@ -0,0 +1,76 @@
+package avsproperty
+
+// nodeRecycler hands out the Nodes of an existing tree to a reader
+// while the structure of the document matches the shape of the tree.
+// All methods are safe to call on a nil nodeRecycler.
+type nodeRecycler struct {
+	root  *Node
+	stack []recycledChildren
+}
+
+type recycledChildren struct {
+	node *Node
+	old  []*Node
+}
+
+// next returns a Node that can be used as the next child of parent, or
+// nil if no matching Node is available. A nil parent refers to the root.
+// A Node matches if it's at the same position and has the same name. If
+// typ is not nil, its type and array flag must match as well.
+//
+// next must be called once for every node that the reader creates, and
+// each call must be paired with a call to leave.
+func (r *nodeRecycler) next(parent *Node, name *NodeName, typ *NodeType, isArray bool) *Node {
+	if r == nil {
+		return nil
+	}
+
+	var node *Node
+	if parent == nil {
+		node, r.root = r.root, nil
+	} else if top := r.stack[len(r.stack)-1]; top.node == parent {
+		if i := len(parent.children); i < len(top.old) {
+			node = top.old[i]
+		}
+	}
+
+	if node == nil || !node.name.Equals(name) ||
+		(typ != nil && (node.nodeType != typ || node.isArray != isArray)) {
+		if node != nil && parent != nil {
+			// its slot is about to be overwritten
+			node.parent = nil
+		}
+		r.stack = append(r.stack, recycledChildren{})
+		return nil
+	}
+
+	r.stack = append(r.stack, recycledChildren{node, node.children})
+	node.parent = nil
+	node.value = nil
+	node.isArray = isArray
+	node.nodeType = VoidNode
+	if typ != nil {
+		node.nodeType = typ
+	}
+	node.children = node.children[:0]
+	node.attributes = node.attributes[:0]
+
+	return node
+}
+
+// leave is called when the reader is done with the children of the
+// node that was created last.
+func (r *nodeRecycler) leave() {
+	if r == nil {
+		return
+	}
+
+	top := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	if top.node != nil {
+		// detach the children that were not reused
+		for _, c := range top.old[len(top.node.children):] {
+			c.parent = nil
+		}
+	}
+}
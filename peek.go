@@ -0,0 +1,156 @@
+package avsproperty
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+)
+
+// PeekRoot reads the name and type of the root node of a document
+// without parsing the rest of the document. The format of the document
+// is inferred like it is by Property.Read. If rd implements
+// io.ByteScanner, no data past the root node is consumed, except for
+// JSON documents, which are read in blocks, and whose root object has
+// to be read entirely if it does not have a type.
+func PeekRoot(rd io.Reader) (name string, typ *NodeType, err error) {
+	if _, ok := rd.(io.ByteScanner); !ok {
+		rd = bufio.NewReader(rd)
+	}
+
+	magic, err := detectFormat(rd.(io.ByteScanner))
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch magic {
+	case binaryMagic >> 8:
+		return peekBinaryRoot(rd)
+	case '<':
+		return peekXMLRoot(rd)
+	case '{', '[':
+		return peekJSONRoot(rd)
+	default:
+		return "", nil, propertyError("could not detect format")
+	}
+}
+
+func peekBinaryRoot(rd io.Reader) (string, *NodeType, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return "", nil, err
+	}
+
+	// skip the metadata size
+	magic := binary.BigEndian.Uint16(header)
+	size := make([]byte, 4)
+	if magic&binaryMagicCompactFlag != 0 {
+		size = size[:2]
+	}
+	if _, err := io.ReadFull(rd, size); err != nil {
+		return "", nil, err
+	}
+
+	var long bool
+	switch magic &^ (binaryMagicDedupeFlag | binaryMagicCompactFlag) {
+	case binaryMagic:
+	case binaryMagicLong:
+		long = true
+	default:
+		return "", nil, propertyError("invalid magic number")
+	}
+
+	id, err := rd.(io.ByteReader).ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	typ := lookupTypeById(id & ^arrayMask)
+	if typ == nil {
+		return "", nil, errMetadata
+	}
+
+	name := &NodeName{}
+	if _, err := name.readBinary(rd, long); err != nil {
+		return "", nil, err
+	}
+	return name.String(), typ, nil
+}
+
+func peekXMLRoot(rd io.Reader) (string, *NodeType, error) {
+	decoder := xml.NewDecoder(rd)
+	decoder.CharsetReader = func(charset string, rd io.Reader) (io.Reader, error) {
+		encoding := EncodingByName(charset)
+		if encoding == nil {
+			return nil, propertyError("encoding not found")
+		}
+		if encoding.charset == nil {
+			return rd, nil
+		}
+		return encoding.charset.NewDecoder().Reader(rd), nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = propertyError("property is empty")
+			}
+			return "", nil, err
+		}
+
+		if elem, ok := token.(xml.StartElement); ok {
+			typ := VoidNode
+			for _, attr := range elem.Attr {
+				if attr.Name.Local == "__type" {
+					if typ = lookupXMLType(attr.Value); typ == nil {
+						return "", nil, propertyError("invalid node type: " + attr.Value)
+					}
+				}
+			}
+			return elem.Name.Local, typ, nil
+		}
+	}
+}
+
+func peekJSONRoot(rd io.Reader) (string, *NodeType, error) {
+	decoder := json.NewDecoder(rd)
+	if token, err := decoder.Token(); err != nil {
+		return "", nil, err
+	} else if token != json.Delim('{') {
+		return "", nil, propertyError("JSON document is not an object")
+	}
+
+	var name, typeName string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", nil, err
+		}
+
+		var v any = &json.RawMessage{}
+		switch token {
+		case "name":
+			v = &name
+		case "__type":
+			v = &typeName
+		}
+		if err := decoder.Decode(v); err != nil {
+			return "", nil, err
+		}
+		if name != "" && typeName != "" {
+			break
+		}
+	}
+	if name == "" {
+		return "", nil, propertyError("root node does not have a name")
+	}
+
+	typ := VoidNode
+	if typeName != "" {
+		if typ = lookupTypeByName(typeName); typ == nil {
+			return "", nil, propertyError("invalid node type: " + typeName)
+		}
+	}
+	return name, typ, nil
+}
@@ -2,10 +2,17 @@ package avsproperty
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"io"
+	"iter"
 	"net"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type propertyError string
@@ -20,23 +27,230 @@ const (
 	FormatBinary PropertyFormat = iota
 	FormatXML
 	FormatPrettyXML
+	// The JSON formats use the representation of Node.MarshalJSON,
+	// where each node is an object with the keys name, __type,
+	// __count, value, attributes, and children. The length of array
+	// values is stored as __count, which also records whether nil
+	// values written using AllowNilValues are arrays. bin
+	// values are encoded as hex strings; base64 is accepted as well
+	// when reading. JSON documents can only be read if they were
+	// written using one of these formats.
+	FormatJSON
+	FormatPrettyJSON
 )
 
+var formatNames = []string{"binary", "xml", "prettyxml", "json", "prettyjson"}
+
+// String returns the name of the format, as accepted by ParsePropertyFormat.
+func (f PropertyFormat) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return "PropertyFormat(" + strconv.Itoa(int(f)) + ")"
+	}
+	return formatNames[f]
+}
+
+// ParsePropertyFormat returns the PropertyFormat whose String method
+// returns s, ignoring case.
+func ParsePropertyFormat(s string) (PropertyFormat, error) {
+	for i, name := range formatNames {
+		if strings.EqualFold(s, name) {
+			return PropertyFormat(i), nil
+		}
+	}
+	return 0, propertyError("unknown format: " + s)
+}
+
 type PropertySettings struct {
-	Format           PropertyFormat
+	Format PropertyFormat
+	// Encoding must be nil or one of the predefined encodings.
+	// Property.SetEncoding should be preferred over assigning
+	// to this field directly.
 	Encoding         *Encoding
 	UseLongNodeNames bool
+
+	// DedupeStrings enables a non-standard extension of the binary
+	// format where identical string values are only written once,
+	// and are referenced by their index afterwards. Documents written
+	// using this extension have a different magic number, and can
+	// only be read by this package.
+	DedupeStrings bool
+
+	// AllowNilValues allows typed nodes that have a nil value to be
+	// written in the XML and JSON formats. In XML, these nodes are
+	// written with their __type attribute and an empty body, which is
+	// read back as the zero value of the type, or an empty array. In
+	// JSON, they are written without a value, and this setting must
+	// also be enabled to read them back the same way. This setting has
+	// no effect on binary writes.
+	AllowNilValues bool
+
+	// ByteOrder defines the byte order of the sizes and values in a
+	// binary document. Since it cannot be detected automatically, it's
+	// used for both reading and writing. If ByteOrder is nil,
+	// binary.BigEndian is used. The header is always big-endian.
+	ByteOrder binary.ByteOrder
+
+	// RecordStringEncodings enables a diagnostic mode where the encoding
+	// that was used to decode the value of each str node is recorded
+	// while reading. Refer to Property.StringEncoding.
+	RecordStringEncodings bool
+
+	// IgnoreEncodingChecksum disables the validation of the encoding
+	// checksum in the header of binary documents, which allows legacy
+	// documents with an invalid checksum to be read. If the encoding
+	// is invalid as well, EncodingNone is used instead.
+	IgnoreEncodingChecksum bool
+
+	// EncodingResolver maps the codepages in the headers of binary
+	// documents to encodings, before the standard codepages are
+	// consulted, which allows documents that use non-standard codepage
+	// numbers to be decoded. If EncodingResolver returns nil, the
+	// standard codepage is used. Documents are always written using
+	// the standard codepage of their encoding.
+	EncodingResolver func(codepage byte) *Encoding
+
+	// EncodeErrorPolicy defines how characters that cannot be
+	// represented by Encoding are handled during write operations.
+	EncodeErrorPolicy EncodeErrorPolicy
+
+	// LenientDecoding replaces invalid sequences in the strings of
+	// documents with U+FFFD while reading, instead of failing. In
+	// documents that are encoded as UTF-8 or ASCII, each invalid UTF-8
+	// sequence is replaced, and otherwise XML documents that contain
+	// them cannot be read, and the strings of binary documents are
+	// left as they are. The decoders of other encodings replace
+	// invalid sequences themselves, but if one fails anyway, decoding
+	// continues after a U+FFFD.
+	LenientDecoding bool
+
+	// LenientASCII disables the validation of the strings that are
+	// written using EncodingASCII, which causes strings that contain
+	// non-ASCII characters to be written as UTF-8 instead of being
+	// handled according to EncodeErrorPolicy.
+	LenientASCII bool
+
+	// MaxDepth limits the depth of the trees that are read. If MaxDepth
+	// is 0, a default limit of 100 is used.
+	MaxDepth int
+
+	// MaxValueSize limits the size in bytes of the values in binary
+	// documents, for both reading and writing. It can be lowered to
+	// harden readers against malicious documents, or raised to allow
+	// large values, which other implementations may not accept. If
+	// MaxValueSize is 0, a default limit of 16 MiB is used.
+	MaxValueSize int
+
+	// MaxXMLTokens limits the number of tokens that are processed when
+	// reading an XML document. A value of 0 disables the limit.
+	MaxXMLTokens int
+
+	// AllowMissingDatabody allows binary documents that end directly
+	// after the metadata section to be read. The nodes of these
+	// documents keep a nil value, and their attributes are empty.
+	AllowMissingDatabody bool
+
+	// MultilineAttributes causes the attributes of nodes that have more
+	// than MultilineAttributes attributes to be written on separate,
+	// indented lines. This setting only has an effect on writes in the
+	// FormatPrettyXML format. A value of 0 disables it.
+	MultilineAttributes int
+
+	// UseCDATA causes the values of str nodes that contain markup
+	// characters to be written inside CDATA sections in the XML
+	// formats, instead of being escaped. Values that cannot be
+	// represented by a CDATA section are escaped as usual.
+	UseCDATA bool
+
+	// Trace defines callbacks that are invoked during binary reads
+	// and writes. It's ignored if nil.
+	Trace *Trace
+
+	// BufferSize defines the size of the buffers that are used to wrap
+	// Readers and Writers that do not implement io.ByteScanner and
+	// io.ByteWriter, respectively. If BufferSize is 0, the default
+	// size of the bufio package is used.
+	BufferSize int
+
+	// TypeHints defines the types of the elements of XML documents that
+	// do not have a __type attribute, which would otherwise be read as
+	// void or str nodes. Elements that have a __type attribute are not
+	// affected.
+	TypeHints Schema
+
+	// LazyStrings defers the decoding of the values of str nodes in
+	// binary documents until they are accessed for the first time.
+	// Since decoding modifies the Node, concurrent access to a tree
+	// that was read using this setting is not safe, even if it is
+	// read-only. Values that cannot be decoded are left undecoded.
+	// This setting has no effect on documents that use the
+	// DedupeStrings extension.
+	LazyStrings bool
+
+	// Compact enables a non-standard variant of the binary format where
+	// the sizes of the metadata and databody sections are 16-bit
+	// instead of 32-bit integers. Documents written using this variant
+	// have a different magic number, and can only be written if
+	// Property.IsCompactEligible reports true.
+	Compact bool
+
+	// PreserveWhitespace records the whitespace between the elements of
+	// XML documents while reading, and writes it in place of the
+	// generated indentation, which allows documents to be written
+	// exactly as they were read. Nodes that were not read, such as
+	// new Nodes, are laid out according to Format as usual. Whitespace
+	// that is not directly inside a void node, and comments, are not
+	// preserved.
+	PreserveWhitespace bool
+
+	// CanonicalizeFloats replaces negative zeros with positive zeros,
+	// and NaNs with a single quiet NaN, in the float and double values
+	// of documents that are read, including vectors and arrays. This
+	// makes the values deterministic at the cost of their exact bit
+	// patterns, which is useful when hashing or deduplicating them.
+	CanonicalizeFloats bool
+
+	// PreserveSettings prevents read operations from overwriting the
+	// settings that describe the document that was read, which are
+	// Format, Encoding, UseLongNodeNames, DedupeStrings, and Compact.
+	// This allows documents to be converted by reading and writing
+	// them using the same Property. The settings of the document are
+	// still available through Property.DetectedSettings.
+	PreserveSettings bool
+
+	// CaseInsensitiveMeta causes the __type, __count, and __size
+	// attributes of XML documents to be recognized regardless of
+	// their case, e.g. __TYPE. Otherwise, attributes whose names
+	// differ in case are read as ordinary attributes.
+	CaseInsensitiveMeta bool
+
+	// StreamBinaryThreshold prevents bin values of binary documents
+	// whose size is at least StreamBinaryThreshold bytes from being
+	// loaded into memory while reading, if the document is read from
+	// an io.ReaderAt that is also an io.Seeker, such as an *os.File.
+	// Instead, Node.BinaryReader reads them directly from the source,
+	// which must not be closed or modified while the tree is in use.
+	// The values are loaded once they are accessed in any other way.
+	// If StreamBinaryThreshold is 0, all values are loaded.
+	StreamBinaryThreshold int
 }
 
 // Property represents a property tree.
 type Property struct {
 	// Settings defines how a property should be serialized.
-	// After a read operation (successful or not) this field
-	// is automatically updatedwith the settings of the
-	// property that was read.
+	// After a read operation (successful or not) Format and
+	// Encoding are automatically updated with the settings of
+	// the document that was read, as are UseLongNodeNames,
+	// DedupeStrings, and Compact if the document is binary,
+	// unless PreserveSettings is enabled. All other fields
+	// are left unmodified.
 	Settings PropertySettings
 
 	Root *Node
+
+	detected        PropertySettings
+	source          *binarySource
+	stringEncodings map[*Node]*Encoding
+	whitespace      map[*Node]*xmlWhitespace
 }
 
 // NewProperty creates a new Property with the default settings
@@ -51,45 +265,248 @@ func NewProperty(root string) (*Property, error) {
 	}, nil
 }
 
+// NodeProperty creates a new Property with the default settings that
+// uses n as its root. n is not detached from its parent, so the Property
+// can be used to serialize a subtree without modifying the original tree
+func NodeProperty(n *Node) *Property {
+	return &Property{
+		Root: n,
+	}
+}
+
+// FromNode creates a new Property with the default settings that uses
+// root as its root. An error is returned if root is nil or has a parent.
+func FromNode(root *Node) (*Property, error) {
+	if root == nil {
+		return nil, propertyError("root is nil")
+	}
+	if root.parent != nil {
+		return nil, root.error("root has a parent")
+	}
+	return NodeProperty(root), nil
+}
+
+// WriteNode serializes and writes the subtree at n to the Writer as a
+// standalone document, using the specified settings. n is not detached
+// from its parent.
+func WriteNode(wr io.Writer, n *Node, settings PropertySettings) error {
+	p := NodeProperty(n)
+	p.Settings = settings
+	return p.Write(wr)
+}
+
 // Read reads a document from the Reader into the Property.
 // The format of the document is automatically inferred from
-// the first byte in the stream
+// the first byte in the stream, after any whitespace in front
+// of XML and JSON documents
 func (p *Property) Read(rd io.Reader) error {
-	p.Root = nil
+	return p.read(rd, nil)
+}
+
+// detectFormat returns the first byte of a document, which identifies
+// its format, without consuming it. Whitespace in front of XML and JSON
+// documents is skipped, while binary documents have to start at the
+// first byte.
+func detectFormat(scan io.ByteScanner) (byte, error) {
+	skipped := false
+	for {
+		c, err := scan.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			skipped = true
+			continue
+		}
+
+		scan.UnreadByte()
+		if skipped && c == binaryMagic>>8 {
+			return 0, propertyError("could not detect format")
+		}
+		return c, nil
+	}
+}
 
+// DocumentInfo describes a document that was read by ReadDetect.
+type DocumentInfo struct {
+	Format           PropertyFormat
+	Encoding         *Encoding
+	UseLongNodeNames bool
+	DedupeStrings    bool
+	Compact          bool
+
+	// NodeCount is the number of nodes in the tree.
+	NodeCount int
+	// Size is the number of bytes that were read from the source.
+	Size int64
+	// DatabodySize is the size of the databody section of binary
+	// documents, excluding its size field, or 0 for other formats.
+	DatabodySize int
+}
+
+// ReadDetect reads a document from the Reader into a new Property with
+// the default settings, and returns information about the document
+// separately, including the settings it was written with, which are
+// not applied to the Settings of the Property. These settings are the
+// same as those returned by Property.DetectedSettings.
+// This allows documents to be inspected without affecting how the
+// Property is written.
+func ReadDetect(rd io.Reader) (*Property, DocumentInfo, error) {
+	var info DocumentInfo
 	if _, ok := rd.(io.ByteScanner); !ok {
 		rd = bufio.NewReader(rd)
 	}
+	counter := &countingReader{rd: rd}
+
+	p := &Property{}
+	p.Settings.PreserveSettings = true
+	p.Settings.Trace = &Trace{
+		OnDatabodyDone: func(bytes int) {
+			info.DatabodySize = bytes
+		},
+	}
+	err := p.Read(counter)
+	p.Settings = PropertySettings{}
+
+	d := p.detected
+	info.Format = d.Format
+	info.Encoding = d.Encoding
+	info.UseLongNodeNames = d.UseLongNodeNames
+	info.DedupeStrings = d.DedupeStrings
+	info.Compact = d.Compact
+	info.Size = counter.n
+	if err != nil {
+		return nil, info, err
+	}
+
+	p.Root.Traverse(func(*Node) error {
+		info.NodeCount++
+		return nil
+	}, nil)
+	return p, info, nil
+}
 
-	scan := rd.(io.ByteScanner)
-	magic, err := scan.ReadByte()
+// ReadAs behaves like Read, but renames the root node of the document
+// to rootName. rootName is validated before anything is read.
+func (p *Property) ReadAs(rd io.Reader, rootName string) error {
+	name, err := NewNodeName(rootName)
 	if err != nil {
 		return err
 	}
-	scan.UnreadByte()
+	if err := p.Read(rd); err != nil {
+		return err
+	}
+	p.Root.name = name
+	return nil
+}
+
+// DetectedSettings returns the Settings of the Property as they were
+// updated by the last read operation, regardless of whether
+// Settings.PreserveSettings is enabled.
+func (p *Property) DetectedSettings() PropertySettings {
+	return p.detected
+}
+
+// ReadInto behaves like Read, but reuses the Nodes of the tree at root
+// instead of allocating new ones wherever the structure of the document
+// matches it. A Node is reused if it has the same name and position
+// within its parent as the corresponding node in the document. When
+// reading a binary document, its type and array flag must match as well.
+// Nodes that do not match are replaced with newly allocated ones, and
+// reused Nodes lose any children or attributes that are not present
+// in the document. If root is nil or has a parent, a new root Node is
+// allocated instead.
+//
+// The tree at root is modified even if an error is returned.
+func (p *Property) ReadInto(rd io.Reader, root *Node) error {
+	recycler := &nodeRecycler{}
+	if root != nil && root.parent == nil {
+		recycler.root = root
+	}
+	return p.read(rd, recycler)
+}
 
-	var reader func(*Property, io.Reader) error
+func (p *Property) read(rd io.Reader, recycler *nodeRecycler) error {
+	settings := p.Settings
+	defer func() {
+		p.detected = p.Settings
+		if settings.PreserveSettings {
+			p.Settings.Format = settings.Format
+			p.Settings.Encoding = settings.Encoding
+			p.Settings.UseLongNodeNames = settings.UseLongNodeNames
+			p.Settings.DedupeStrings = settings.DedupeStrings
+			p.Settings.Compact = settings.Compact
+		}
+	}()
+
+	p.Root = nil
+	p.stringEncodings = nil
+	if p.Settings.RecordStringEncodings {
+		p.stringEncodings = make(map[*Node]*Encoding)
+	}
+	p.whitespace = nil
+	if p.Settings.PreserveWhitespace {
+		p.whitespace = make(map[*Node]*xmlWhitespace)
+	}
+
+	if p.Settings.StreamBinaryThreshold > 0 {
+		p.source = newBinarySource(rd)
+		defer func() { p.source = nil }()
+	}
+
+	if _, ok := rd.(io.ByteScanner); !ok {
+		if size := p.Settings.BufferSize; size > 0 {
+			rd = bufio.NewReaderSize(rd, size)
+		} else {
+			rd = bufio.NewReader(rd)
+		}
+	}
+
+	magic, err := detectFormat(rd.(io.ByteScanner))
+	if err != nil {
+		return err
+	}
+
+	var reader func(*Property, io.Reader, *nodeRecycler) error
 	switch magic {
 	case binaryMagic >> 8:
 		reader = readBinary
 	case '<':
 		reader = readXML
+	case '{', '[':
+		reader = readJSON
 	default:
 		return propertyError("could not detect format")
 	}
-	return reader(p, rd)
+	if err := reader(p, rd, recycler); err != nil {
+		return err
+	}
+
+	if p.Settings.CanonicalizeFloats {
+		p.Root.Traverse(func(n *Node) error {
+			n.canonicalizeFloats()
+			return nil
+		}, nil)
+	}
+	return nil
 }
 
 // Write serializes and writes the property to the Writer.
 // The way in which the Property is serialized is defined
 // by its Settings field.
 func (p *Property) Write(wr io.Writer) error {
-	if p.Root == nil {
-		return propertyError("property is empty")
+	if err := p.Validate(); err != nil {
+		return err
 	}
 
 	if _, ok := wr.(io.ByteWriter); !ok {
-		bio := bufio.NewWriter(wr)
+		var bio *bufio.Writer
+		if size := p.Settings.BufferSize; size > 0 {
+			bio = bufio.NewWriterSize(wr, size)
+		} else {
+			bio = bufio.NewWriter(wr)
+		}
 		defer bio.Flush()
 		wr = bio
 	}
@@ -102,12 +519,78 @@ func (p *Property) Write(wr io.Writer) error {
 		fallthrough
 	case FormatXML:
 		writer = writeXML
+	case FormatPrettyJSON:
+		fallthrough
+	case FormatJSON:
+		writer = writeJSON
 	default:
 		panic("invalid format")
 	}
 	return writer(p, wr)
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler. The Property is
+// written in the binary format, regardless of Settings.Format, which is
+// left unmodified.
+func (p *Property) MarshalBinary() ([]byte, error) {
+	format := p.Settings.Format
+	defer func() {
+		p.Settings.Format = format
+	}()
+
+	p.Settings.Format = FormatBinary
+	wr := &bytes.Buffer{}
+	if err := p.Write(wr); err != nil {
+		return nil, err
+	}
+	return wr.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. Unlike Read,
+// it only accepts documents in the binary format.
+func (p *Property) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 || b[0] != binaryMagic>>8 {
+		return propertyError("invalid magic number")
+	}
+	return p.Read(bytes.NewReader(b))
+}
+
+// Validate checks whether the Property can be serialized using its
+// current settings, without writing anything. Write calls Validate
+// before any data is written, so that invalid trees don't result in
+// partially written documents.
+func (p *Property) Validate() error {
+	if p.Root == nil {
+		return propertyError("property is empty")
+	}
+
+	allowNil := p.Settings.Format != FormatBinary && p.Settings.AllowNilValues
+	return p.Root.Traverse(func(n *Node) error {
+		if !n.name.valid() {
+			return propertyError("invalid node name")
+		}
+		for _, attrib := range n.attributes {
+			if !attrib.key.valid() {
+				return n.error("invalid attribute key")
+			}
+		}
+
+		if n.nodeType == VoidNode {
+			return nil
+		}
+		if n.value == nil {
+			if allowNil {
+				return nil
+			}
+			return n.error("node contains a nil value")
+		}
+		if size := n.ArrayLength() * n.nodeType.size; p.Settings.Format == FormatBinary && size > p.maxValueSize() {
+			return n.error("value too large: " + strconv.Itoa(size))
+		}
+		return nil
+	}, nil)
+}
+
 // Write serializes and writes the property to a file
 // at the specified path. The way in which the Property
 // should be serialized is defined by its Settings field.
@@ -121,6 +604,59 @@ func (p *Property) WriteFile(filename string) error {
 	return p.Write(f)
 }
 
+// FormatFile describes a file that a Property is written to by
+// Property.WriteFormats.
+type FormatFile struct {
+	Path   string
+	Format PropertyFormat
+}
+
+// WriteFormats writes the Property to each of the files, using the
+// format of the file instead of Settings.Format, which is restored
+// afterwards. If an error occurs, all of the files that have been
+// created are removed.
+func (p *Property) WriteFormats(files ...FormatFile) error {
+	format := p.Settings.Format
+	defer func() {
+		p.Settings.Format = format
+	}()
+
+	for i, f := range files {
+		p.Settings.Format = f.Format
+		if err := p.writeFormatFile(f.Path); err != nil {
+			for _, f := range files[:i] {
+				os.Remove(f.Path)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFormatFile behaves like WriteFile, but removes the file
+// if it could be created, but not written
+func (p *Property) writeFormatFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = p.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
+}
+
+// WriteBoth writes the Property to binPath in the binary format, and
+// to xmlPath in the FormatPrettyXML format. Refer to WriteFormats.
+func (p *Property) WriteBoth(binPath, xmlPath string) error {
+	return p.WriteFormats(FormatFile{binPath, FormatBinary}, FormatFile{xmlPath, FormatPrettyXML})
+}
+
 // Read reads a document from a file at the specified path into the
 // Property. The format of the document is automatically inferred
 // from the first byte in the file
@@ -143,6 +679,52 @@ func (p *Property) Encoding() *Encoding {
 	return p.Settings.Encoding
 }
 
+// SetEncoding sets the Property's encoding to e after ensuring that it's
+// one of the predefined encodings. A nil value is treated as EncodingNone
+func (p *Property) SetEncoding(e *Encoding) error {
+	if e != nil && !e.valid() {
+		return propertyError("unknown encoding")
+	}
+	p.Settings.Encoding = e
+	return nil
+}
+
+// StringEncoding returns the encoding that was used to decode the value
+// of the str node n during the last read operation. EncodingNone is
+// returned if the value was read as raw bytes without being decoded,
+// which is the case for ASCII and UTF-8. nil is returned if n was not
+// read as a str node, or if Settings.RecordStringEncodings was disabled.
+func (p *Property) StringEncoding(n *Node) *Encoding {
+	return p.stringEncodings[n]
+}
+
+func (p *Property) recordStringEncoding(n *Node, e *Encoding) {
+	if p.stringEncodings != nil {
+		p.stringEncodings[n] = e
+	}
+}
+
+func (p *Property) maxDepth() int {
+	if p.Settings.MaxDepth == 0 {
+		return maxMetaDepth
+	}
+	return p.Settings.MaxDepth
+}
+
+func (p *Property) maxValueSize() int {
+	if p.Settings.MaxValueSize == 0 {
+		return maxValueSize
+	}
+	return p.Settings.MaxValueSize
+}
+
+func (p *Property) byteOrder() binary.ByteOrder {
+	if p.Settings.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return p.Settings.ByteOrder
+}
+
 // Attribute represents an attribute in a property tree
 type Attribute struct {
 	key   *NodeName
@@ -191,10 +773,157 @@ func NewNodeWithValue(name string, value any) (*Node, error) {
 	return n, nil
 }
 
+// NewNodeFromStrings creates a new Node with a value of the type with
+// the specified name, which is parsed from its textual representation
+// in the XML formats. If value consists of more than one vector's worth
+// of space-separated elements, the Node is given an array value.
+// Values are represented in the same way as values that were read
+// from an XML document.
+func NewNodeFromStrings(name, typeName, value string) (*Node, error) {
+	n, err := NewNode(name)
+	if err != nil {
+		return nil, err
+	}
+	nt := lookupTypeByName(typeName)
+	if nt == nil {
+		return nil, n.error("invalid node type: " + typeName)
+	}
+	if err := n.setValueString(nt, value, false); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// setValueString parses value as a value of type nt, and assigns it to
+// the Node. If array is true, the value is always parsed as an array.
+// The Node is left unmodified if an error is returned.
+func (n *Node) setValueString(nt *NodeType, value string, array bool) (err error) {
+	var (
+		v       any
+		isArray bool
+	)
+	switch nt {
+	case VoidNode:
+		if strings.TrimSpace(value) != "" {
+			return n.error("void nodes cannot have a value")
+		}
+
+	case StrNode:
+		v = value
+
+	case BinNode:
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return n.error("invalid binary value: " + err.Error())
+		}
+		v = BinValue(b)
+
+	default:
+		stv := stringToValueFunc(nt)
+		split := strings.Fields(value)
+		if (len(split) == 0 && !array) || len(split)%nt.count != 0 {
+			return n.error("invalid number of elements in value")
+		}
+
+		if len(split) == nt.count && !array {
+			if v, err = stv(strings.Join(split, " ")); err != nil {
+				return n.valueError(err)
+			}
+			break
+		}
+
+		slice := make([]any, len(split)/nt.count)
+		for i := range slice {
+			start := i * nt.count
+			if slice[i], err = stv(strings.Join(split[start:start+nt.count], " ")); err != nil {
+				return n.valueError(err)
+			}
+		}
+		v = slice
+		isArray = true
+	}
+
+	n.nodeType = nt
+	n.value = v
+	n.isArray = isArray
+	return nil
+}
+
+func (n *Node) valueError(err error) error {
+	s := err.Error()
+	if err, ok := err.(propertyError); ok {
+		s = string(err)
+	}
+	return n.error("invalid value: " + s)
+}
+
 func (n *Node) Parent() *Node {
 	return n.parent
 }
 
+// Root returns the topmost ancestor of the Node, or the Node itself
+// if it does not have a parent.
+func (n *Node) Root() *Node {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// Depth returns the number of ancestors of the Node.
+func (n *Node) Depth() int {
+	depth := 0
+	for p := n.parent; p != nil; p = p.parent {
+		depth++
+	}
+	return depth
+}
+
+// Index returns the position of the Node among the children of its
+// parent, or -1 if the Node does not have a parent.
+func (n *Node) Index() int {
+	if n.parent == nil {
+		return -1
+	}
+	for i, c := range n.parent.children {
+		if c == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// NextSibling returns the child of the Node's parent that follows the
+// Node, or nil if the Node is the last child or does not have a parent.
+func (n *Node) NextSibling() *Node {
+	if i := n.Index(); i >= 0 && i+1 < len(n.parent.children) {
+		return n.parent.children[i+1]
+	}
+	return nil
+}
+
+// PrevSibling returns the child of the Node's parent that precedes the
+// Node, or nil if the Node is the first child or does not have a parent.
+func (n *Node) PrevSibling() *Node {
+	if i := n.Index(); i > 0 {
+		return n.parent.children[i-1]
+	}
+	return nil
+}
+
+// Path returns the slash-delimited names of the Node and its ancestors,
+// starting with the root of its tree, e.g. "root/info/version".
+func (n *Node) Path() string {
+	var names []string
+	for c := n; c != nil; c = c.parent {
+		names = append(names, c.name.String())
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, "/")
+}
+
 func (n *Node) Name() *NodeName {
 	return n.name
 }
@@ -204,7 +933,7 @@ func (n *Node) Type() *NodeType {
 }
 
 func (n *Node) Value() any {
-	return n.value
+	return n.resolvedValue()
 }
 
 func (n *Node) IsArray() bool {
@@ -248,15 +977,23 @@ func (n *Node) Children() []*Node {
 }
 
 // SearchChildren returns a list of the Node's children
-// with the specified name
+// with the specified name. Surrounding whitespace in name
+// is ignored
 func (n *Node) SearchChildren(name string) []*Node {
-	if name, err := NewNodeName(name); err != nil {
+	if name, err := searchName(name); err != nil {
 		return nil
 	} else {
 		return n.SearchChildrenNodeName(name)
 	}
 }
 
+// searchName converts a name that was passed to one of the search
+// functions. Surrounding whitespace is ignored, since it can never be
+// part of a valid name.
+func searchName(name string) (*NodeName, error) {
+	return NewNodeName(strings.TrimSpace(name))
+}
+
 // SearchChildrenNodeName returns a list of the Node's children
 // with the specified name
 func (n *Node) SearchChildrenNodeName(name *NodeName) []*Node {
@@ -272,9 +1009,10 @@ func (n *Node) SearchChildrenNodeName(name *NodeName) []*Node {
 }
 
 // SearchChild returns the first child of the Node with the
-// specified name, or nil if no child is found
+// specified name, or nil if no child is found. Surrounding
+// whitespace in name is ignored
 func (n *Node) SearchChild(name string) *Node {
-	if name, err := NewNodeName(name); err != nil {
+	if name, err := searchName(name); err != nil {
 		return nil
 	} else {
 		return n.SearchChildNodeName(name)
@@ -295,7 +1033,7 @@ func (n *Node) SearchChildNodeName(name *NodeName) *Node {
 // ChildValue returns the value of the first child of the
 // Node with the specified name, or nil if no child is found
 func (n *Node) ChildValue(name string) any {
-	if name, err := NewNodeName(name); err != nil {
+	if name, err := searchName(name); err != nil {
 		return nil
 	} else {
 		return n.ChildValueNodeName(name)
@@ -307,7 +1045,7 @@ func (n *Node) ChildValue(name string) any {
 func (n *Node) ChildValueNodeName(name *NodeName) any {
 	child := n.SearchChildNodeName(name)
 	if child != nil {
-		return child.value
+		return child.resolvedValue()
 	}
 	return nil
 }
@@ -322,7 +1060,7 @@ func (n *Node) Attributes() []*Attribute {
 // SearchAttributeNodeName returns an attribute with the
 // specified key, or nil if no attribute is found
 func (n *Node) SearchAttribute(k string) *Attribute {
-	if k, err := NewNodeName(k); err != nil {
+	if k, err := searchName(k); err != nil {
 		return nil
 	} else {
 		return n.SearchAttributeNodeName(k)
@@ -344,7 +1082,7 @@ func (n *Node) SearchAttributeNodeName(k *NodeName) *Attribute {
 // specified key. If the attribute is not present, an empty
 // string is returned instead
 func (n *Node) AttributeValue(k string) string {
-	if k, err := NewNodeName(k); err != nil {
+	if k, err := searchName(k); err != nil {
 		return ""
 	} else {
 		return n.AttributeValueNodeName(k)
@@ -371,7 +1109,10 @@ func (n *Node) ArrayLength() int {
 		!n.isArray || n.value == nil {
 		return 1
 	}
-	return reflect.ValueOf(n.value).Len()
+	if sb, ok := n.value.(*streamedBinary); ok {
+		return int(sb.data.Size())
+	}
+	return reflect.ValueOf(n.resolvedValue()).Len()
 }
 
 // IntValue returns the Node's value as a signed integer, or 0 if the
@@ -408,22 +1149,129 @@ func (n *Node) UintValue() uint64 {
 	}
 }
 
+// FloatValue returns the Node's float or double value as a float64, or
+// 0 if the Node does not contain a float or double value. Since vector
+// and array values are not scalars, 0 is returned for them as well.
+func (n *Node) FloatValue() float64 {
+	switch v := n.value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
+
+// BoolValue returns the Node's value as a bool, or false if the Node
+// does not contain a bool value.
+func (n *Node) BoolValue() bool {
+	b, _ := n.value.(BoolValue)
+	return bool(b)
+}
+
+// SetBool turns the Node into a bool node with the value b.
+func (n *Node) SetBool(b bool) error {
+	return n.SetValue(BoolValue(b))
+}
+
+// IPValue returns the Node's value as a net.IP, or nil if the Node is
+// not an ip4 node that contains a single address.
+func (n *Node) IPValue() net.IP {
+	if n.nodeType != IPv4Node || n.isArray {
+		return nil
+	}
+	ip, _ := n.value.(net.IP)
+	return ip
+}
+
+// SetIP turns the Node into an ip4 node with the value ip, which must
+// be an IPv4 address.
+func (n *Node) SetIP(ip net.IP) error {
+	if ip.To4() == nil {
+		return n.error("invalid ip: " + ip.String())
+	}
+	return n.SetValue(ip)
+}
+
 // StringValue returns the Node's value as a string, or an empty string
 // if the Node does not contain a string value.
 func (n *Node) StringValue() string {
-	s, _ := n.value.(string)
+	s, _ := n.resolvedValue().(string)
 	return s
 }
 
 // BinaryValue returns the Node's value as a BinValue, or nil
 // if the Node does not contain a BinValue.
 func (n *Node) BinaryValue() BinValue {
-	b, _ := n.value.(BinValue)
+	b, _ := n.resolvedValue().(BinValue)
 	return b
 }
 
+// Bit reports whether the i-th least significant bit of the Node's
+// unsigned integer value is set. false is returned if the Node does
+// not contain an unsigned integer value, or if i is out of range.
+func (n *Node) Bit(i int) bool {
+	if !n.isUnsigned() || i < 0 || i >= n.nodeType.size*8 {
+		return false
+	}
+	return n.UintValue()&(1<<i) != 0
+}
+
+// SetBit sets or clears the i-th least significant bit of the Node's
+// unsigned integer value. The type of the value is preserved.
+func (n *Node) SetBit(i int, v bool) error {
+	if !n.isUnsigned() {
+		return n.error("node does not contain an unsigned integer value")
+	}
+	if i < 0 || i >= n.nodeType.size*8 {
+		return n.error("bit index out of range: " + strconv.Itoa(i))
+	}
+
+	u := n.UintValue()
+	if v {
+		u |= 1 << i
+	} else {
+		u &^= 1 << i
+	}
+	return n.SetValue(reflect.ValueOf(u).Convert(n.nodeType.rt).Interface())
+}
+
+// Flags decomposes the Node's unsigned integer value into its bits,
+// starting with the least significant bit. nil is returned if the Node
+// does not contain an unsigned integer value.
+func (n *Node) Flags() []bool {
+	if !n.isUnsigned() {
+		return nil
+	}
+	flags := make([]bool, n.nodeType.size*8)
+	for i := range flags {
+		flags[i] = n.Bit(i)
+	}
+	return flags
+}
+
+func (n *Node) isUnsigned() bool {
+	switch n.value.(type) {
+	case uint8, uint16, uint32, uint64:
+		return true
+	default:
+		return false
+	}
+}
+
 // AppendChild adds c as the last child of the Node.
 func (n *Node) AppendChild(c *Node) error {
+	return n.InsertChildAt(len(n.children), c)
+}
+
+// InsertChildAt adds c to the Node's children at index i, which must be
+// in the range [0, len(Children())]. Like AppendChild, it turns the Node
+// into a void node, and fails if c already has a parent.
+func (n *Node) InsertChildAt(i int, c *Node) error {
+	if i < 0 || i > len(n.children) {
+		return n.error("child index out of range")
+	}
 	if c.parent != nil {
 		return n.error("child already has a parent")
 	}
@@ -434,11 +1282,98 @@ func (n *Node) AppendChild(c *Node) error {
 	}
 
 	c.parent = n
-	n.children = append(n.children, c)
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = c
 
 	return nil
 }
 
+// Cut removes the Node from the children of its parent, so that it can
+// be added to another Node. An error is returned if the Node does not
+// have a parent.
+func (n *Node) Cut() error {
+	if n.parent == nil {
+		return n.error("node does not have a parent")
+	}
+	return n.parent.RemoveChild(n)
+}
+
+// MoveTo removes the Node from the children of its parent, if it has
+// one, and adds it as the last child of parent. An error is returned if
+// parent is nil, or the Node itself or one of its descendants, in which
+// case the tree is left unmodified.
+func (n *Node) MoveTo(parent *Node) error {
+	if parent == nil {
+		return n.error("cannot move node to nil parent")
+	}
+	for p := parent; p != nil; p = p.parent {
+		if p == n {
+			return n.error("cannot move node under itself or one of its descendants")
+		}
+	}
+
+	if n.parent != nil {
+		if err := n.Cut(); err != nil {
+			return err
+		}
+	}
+	return parent.AppendChild(n)
+}
+
+// RemoveChild removes c from the Node's children. An error is returned
+// if c is not a child of the Node. The Node remains a void node, even
+// if c was its last child.
+func (n *Node) RemoveChild(c *Node) error {
+	for i, child := range n.children {
+		if child == c {
+			return n.RemoveChildAt(i)
+		}
+	}
+	return n.error("node is not a child")
+}
+
+// RemoveChildAt removes the Node's child at index i.
+func (n *Node) RemoveChildAt(i int) error {
+	if i < 0 || i >= len(n.children) {
+		return n.error("child index out of range")
+	}
+
+	c := n.children[i]
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+	c.parent = nil
+
+	return nil
+}
+
+// SwapChildren swaps the positions of the Node's children at
+// indices i and j.
+func (n *Node) SwapChildren(i, j int) error {
+	if i < 0 || i >= len(n.children) || j < 0 || j >= len(n.children) {
+		return n.error("child index out of range")
+	}
+	n.children[i], n.children[j] = n.children[j], n.children[i]
+	return nil
+}
+
+// SortChildren sorts the Node's children in place using less. The sort
+// is stable, so children that are equal keep their relative order.
+func (n *Node) SortChildren(less func(a, b *Node) bool) {
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return less(n.children[i], n.children[j])
+	})
+}
+
+// SortChildrenByName sorts the Node's children in place by name.
+// Children with the same name keep their relative order.
+func (n *Node) SortChildrenByName() {
+	n.SortChildren(func(a, b *Node) bool {
+		return a.name.String() < b.name.String()
+	})
+}
+
 // NewNode creates a new Node, and adds it as the last child of the Node.
 func (n *Node) NewNode(name string) (*Node, error) {
 	c, err := NewNode(name)
@@ -488,12 +1423,26 @@ func (n *Node) SetAttribute(k, v string) error {
 }
 
 // SetValue sets the Node's value to v. Refer to type.go to see how
-// Go types are mapped to Property types.
+// Go types are mapped to Property types. Empty slices are valid array
+// values, and are preserved by both the binary and XML formats. Nil
+// slices are stored as empty arrays. Pointers are dereferenced, and
+// are rejected if they are nil, like a nil v is.
 func (n *Node) SetValue(v any) error {
 	if len(n.children) > 0 {
 		return n.error("cannot assign value to node that has children")
 	}
 
+	if rv := reflect.ValueOf(v); !rv.IsValid() {
+		return n.error("cannot set nil value")
+	} else if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return n.error("cannot set nil value")
+		}
+		v = rv.Elem().Interface()
+	} else if rv.Kind() == reflect.Slice && rv.IsNil() && rv.Type() != BinNode.rt && rv.Type() != IPv4Node.rt {
+		v = reflect.MakeSlice(rv.Type(), 0, 0).Interface()
+	}
+
 	if v, ok := v.(net.IP); ok && v.To4() == nil {
 		return n.error("invalid ip size")
 	}
@@ -520,6 +1469,19 @@ func (n *Node) SetValue(v any) error {
 	return nil
 }
 
+// SetValueAuto behaves like SetValue, except when v is a string and
+// the Node has a type other than void. In this case, v is parsed as a
+// value of the Node's current type, using the same rules as
+// NewNodeFromStrings. If the Node already contains an array value, v
+// is always parsed as an array. Passing a string to a void Node turns it
+// into a str node, like SetValue does.
+func (n *Node) SetValueAuto(v any) error {
+	if s, ok := v.(string); ok && n.nodeType != VoidNode {
+		return n.setValueString(n.nodeType, s, n.isArray)
+	}
+	return n.SetValue(v)
+}
+
 func (n *Node) Traverse(start, end func(*Node) error) error {
 	if start != nil {
 		if err := start(n); err != nil {
@@ -539,6 +1501,37 @@ func (n *Node) Traverse(start, end func(*Node) error) error {
 	return nil
 }
 
+// All returns an iterator over n and its descendants in document order.
+// The tree must not be modified while it's being iterated over.
+func (n *Node) All() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		n.all(yield)
+	}
+}
+
+// Descendants behaves like All, but does not yield n itself.
+func (n *Node) Descendants() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		for _, child := range n.children {
+			if !child.all(yield) {
+				return
+			}
+		}
+	}
+}
+
+func (n *Node) all(yield func(*Node) bool) bool {
+	if !yield(n) {
+		return false
+	}
+	for _, child := range n.children {
+		if !child.all(yield) {
+			return false
+		}
+	}
+	return true
+}
+
 func (n *Node) error(s string) error {
 	return propertyError(n.name.String() + ": " + s)
 }
@@ -2,11 +2,24 @@ package avsproperty
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	textencoding "golang.org/x/text/encoding"
 )
 
 var (
@@ -137,15 +150,4003 @@ func TestCopy(t *testing.T) {
 	t.Logf("copy     : %+v", copy)
 }
 
-func BenchmarkReadBinary(b *testing.B) {
-	prop := Property{}
-	rd := bytes.NewReader(testcaseXML)
-	for i := 0; i < b.N; i++ {
-		if err := prop.Read(rd); err != nil {
-			b.Fatal(err)
+func TestReadInto(t *testing.T) {
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(testcaseBinary)); err != nil {
+		t.Fatal(err)
+	}
+	root := prop.Root
+	child := root.children[0]
+
+	if err := prop.ReadInto(bytes.NewReader(testcaseBinary), root); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root != root || prop.Root.children[0] != child {
+		t.Fatal("nodes were not reused")
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wr.Bytes(), testcaseBinary) {
+		t.Fatal("reused tree does not match")
+	}
+
+	other, _ := NewNode("other")
+	if err := prop.ReadInto(bytes.NewReader(testcaseBinary), other); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root == other {
+		t.Fatal("mismatched root was reused")
+	}
+}
+
+func TestDedupeStrings(t *testing.T) {
+	prop, _ := NewProperty("root")
+	for i := 0; i < 16; i++ {
+		child, _ := prop.Root.NewNodeWithValue("entry", "the same string value")
+		child.SetAttribute("id", strconv.Itoa(i%2))
+	}
+	prop.Settings.Encoding = EncodingSJIS
+
+	plain := &bytes.Buffer{}
+	if err := prop.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	prop.Settings.DedupeStrings = true
+	deduped := &bytes.Buffer{}
+	if err := prop.Write(deduped); err != nil {
+		t.Fatal(err)
+	}
+	if deduped.Len() >= plain.Len() {
+		t.Fatal("deduplication did not reduce size")
+	}
+
+	for i, data := range [][]byte{plain.Bytes(), deduped.Bytes()} {
+		read := &Property{}
+		if err := read.Read(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if read.Settings.DedupeStrings != (i == 1) {
+			t.Fatal("incorrect DedupeStrings setting")
+		}
+
+		read.Settings.DedupeStrings = false
+		wr := &bytes.Buffer{}
+		if err := read.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wr.Bytes(), plain.Bytes()) {
+			t.Fatal("roundtrip failed")
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	root, _ := NewNode("root")
+	player, _ := root.NewNode("player")
+	player.SetAttribute("id", "1")
+	stats, _ := player.NewNode("stats")
+	stats.NewNodeWithValue("hp", uint16(100))
+	stats.NewNodeWithValue("speed", float32(1.5))
+	player.NewNodeWithValue("name", "test")
+
+	if q := root.Q("player/stats/hp"); !q.Exists() || q.Int() != 100 || q.Uint() != 100 {
+		t.Fatal("incorrect hp")
+	}
+	if root.Q("player/stats/speed").Float() != 1.5 {
+		t.Fatal("incorrect speed")
+	}
+	if q := root.Q("player"); q.Attribute("id") != "1" || q.Q("name").String() != "test" {
+		t.Fatal("incorrect player")
+	}
+
+	q := root.Q("player/missing/hp")
+	if q.Exists() || q.Node() != nil || q.Int() != 0 || q.Float() != 0 || q.String() != "" || q.Q("foo").Exists() {
+		t.Fatal("absent path returned a value")
+	}
+	if root.Q("player/stats/speed").Int() != 0 {
+		t.Fatal("float returned as integer")
+	}
+}
+
+func TestAllowNilValues(t *testing.T) {
+	const template = `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<root><a __type="s32"></a><b __type="u8" __count="0"></b></root>`
+
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	prop.Settings.Encoding = EncodingUTF8
+	prop.Root.NewNodeWithValue("a", int32(1))
+	prop.Root.NewNodeWithValue("b", []uint8{1})
+	for _, c := range prop.Root.Children() {
+		c.value = nil
+	}
+	if prop.Write(io.Discard) == nil {
+		t.Fatal("nil value was written")
+	}
+
+	prop.Settings.AllowNilValues = true
+	wr := &strings.Builder{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if wr.String() != template {
+		t.Fatalf("unexpected output: %s", wr.String())
+	}
+
+	// the template is read back with empty values, which can be
+	// written in any format
+	result := &Property{}
+	if err := result.Read(strings.NewReader(wr.String())); err != nil {
+		t.Fatal(err)
+	}
+	if a := result.Root.SearchChild("a"); a.Value() != int32(0) || a.Type() != S32Node {
+		t.Fatal("unexpected value:", a.Value())
+	}
+	if b := result.Root.SearchChild("b"); b.Type() != U8Node || !b.IsArray() || b.ArrayLength() != 0 {
+		t.Fatal("unexpected value:", b.Value())
+	}
+	for _, format := range []PropertyFormat{FormatPrettyXML, FormatBinary} {
+		result.Settings.Format = format
+		if err := result.Write(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMaxXMLTokens(t *testing.T) {
+	doc := &strings.Builder{}
+	doc.WriteString("<root>")
+	for i := 0; i < 10000; i++ {
+		doc.WriteString("<a __type=\"s32\">1</a>")
+	}
+	doc.WriteString("</root>")
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(doc.String())); err != nil {
+		t.Fatal(err)
+	}
+	prop.Settings.MaxXMLTokens = 1000
+	if err := prop.Read(strings.NewReader(doc.String())); err == nil {
+		t.Fatal("token limit was not enforced")
+	}
+
+	const laughs = `<?xml version="1.0"?>
+<!DOCTYPE lolz [
+<!ENTITY lol "lol">
+<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+]>
+<lolz __type="str">&lol2;</lolz>`
+	if err := prop.Read(strings.NewReader(laughs)); err == nil {
+		t.Fatal("entity was expanded")
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	prop := &Property{}
+	if err := prop.SetEncoding(&Encoding{}); err == nil {
+		t.Fatal("bogus encoding was accepted")
+	}
+	if err := prop.SetEncoding(&Encoding{codepage: 4, name: "SHIFT_JIS"}); err == nil {
+		t.Fatal("copy of encoding was accepted")
+	}
+	if prop.Encoding() != EncodingNone {
+		t.Fatal("encoding was modified")
+	}
+
+	for _, e := range []*Encoding{EncodingSJIS, EncodingNone, nil} {
+		if err := prop.SetEncoding(e); err != nil {
+			t.Fatal(err)
+		}
+		if prop.Settings.Encoding != e {
+			t.Fatal("encoding was not set")
+		}
+	}
+}
+
+func TestBoundaryPacking(t *testing.T) {
+	values := []any{
+		int8(-1), int16(-2), BoolValue(true), uint8(4),
+		[2]uint8{5, 6}, [3]int8{7, 8, 9}, int32(10), "eleven",
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		prop, _ := NewProperty("root")
+		for j := rnd.Intn(32); j >= 0; j-- {
+			child, _ := prop.Root.NewNodeWithValue("v", values[rnd.Intn(len(values))])
+			if rnd.Intn(4) == 0 {
+				child.SetAttribute("attr", "foo")
+			}
+		}
+
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		read := &Property{}
+		if err := read.Read(bytes.NewReader(wr.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		for j, child := range read.Root.children {
+			if fmt.Sprint(child.value) != fmt.Sprint(prop.Root.children[j].value) {
+				t.Fatalf("%d: value mismatch: %v != %v", j, child.value, prop.Root.children[j].value)
+			}
+		}
+	}
+}
+
+func TestWriteNode(t *testing.T) {
+	root, _ := NewNode("root")
+	mid, _ := root.NewNode("mid")
+	mid.SetAttribute("hoge", "fuga")
+	mid.NewNodeWithValue("child", int32(123))
+
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		wr := &bytes.Buffer{}
+		if err := WriteNode(wr, mid, PropertySettings{Format: format}); err != nil {
+			t.Fatal(err)
+		}
+		if mid.parent != root || root.children[0] != mid {
+			t.Fatal("node was detached")
+		}
+
+		prop := &Property{}
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if prop.Root.name.String() != "mid" || prop.Root.AttributeValue("hoge") != "fuga" ||
+			prop.Root.SearchChild("child").IntValue() != 123 {
+			t.Fatal("subtree does not match")
+		}
+	}
+}
+
+func TestReadEmptyXML(t *testing.T) {
+	const doc = `<root><a __type="s32"/><b __type="str"/><c __type="bin"/>` +
+		`<d __type="u8" __count="0"/><e __type="2s8"/><f __type="ip4"></f></root>`
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.ChildValue("a"); v != int32(0) {
+		t.Fatalf("unexpected s32 value: %v", v)
+	}
+	if v := prop.Root.ChildValue("b"); v != "" {
+		t.Fatalf("unexpected str value: %v", v)
+	}
+	if v := prop.Root.ChildValue("c"); !reflect.DeepEqual(v, BinValue{}) {
+		t.Fatalf("unexpected bin value: %v", v)
+	}
+	if v := prop.Root.SearchChild("d"); v.ArrayLength() != 0 || !v.IsArray() {
+		t.Fatal("unexpected array value")
+	}
+
+	prop.Settings.Format = FormatBinary
+	if err := prop.Write(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prop.Read(strings.NewReader(`<root __type="u8" __count="2"/>`)); err == nil {
+		t.Fatal("empty array with non-zero count was accepted")
+	}
+}
+
+func TestBits(t *testing.T) {
+	for _, v := range []any{uint8(0x81), uint16(0x8001), uint32(0x80000001)} {
+		node, _ := NewNodeWithValue("flags", v)
+		size := node.Type().size * 8
+
+		flags := node.Flags()
+		if len(flags) != size || !flags[0] || !flags[size-1] || flags[1] {
+			t.Fatalf("%T: unexpected flags: %v", v, flags)
+		}
+		if !node.Bit(size-1) || node.Bit(size) || node.Bit(-1) {
+			t.Fatalf("%T: unexpected bit", v)
+		}
+
+		if err := node.SetBit(1, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.SetBit(0, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.SetBit(size, true); err == nil {
+			t.Fatalf("%T: out of range bit was set", v)
+		}
+		if reflect.TypeOf(node.Value()) != reflect.TypeOf(v) {
+			t.Fatalf("%T: type was not preserved", v)
+		}
+		if node.UintValue() != 1<<(size-1)|2 {
+			t.Fatalf("%T: unexpected value: %x", v, node.UintValue())
+		}
+	}
+
+	node, _ := NewNodeWithValue("signed", int32(1))
+	if node.Bit(0) || node.Flags() != nil || node.SetBit(0, true) == nil {
+		t.Fatal("bits of signed node were accessed")
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	for _, testcase := range [][]byte{testcaseBinary, testcaseBinaryLong} {
+		prop := &Property{}
+		if err := prop.Read(bytes.NewReader(testcase)); err != nil {
+			t.Fatal(err)
+		}
+
+		prop.Settings.ByteOrder = binary.LittleEndian
+		little := &bytes.Buffer{}
+		if err := prop.Write(little); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(little.Bytes(), testcase) {
+			t.Fatal("byte order was not changed")
 		}
+
 		prop.Root = nil
-		rd.Reset(testcaseBinary)
+		if err := prop.Read(little); err != nil {
+			t.Fatal(err)
+		}
+		prop.Settings.ByteOrder = binary.BigEndian
+		big := &bytes.Buffer{}
+		if err := prop.Write(big); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(big.Bytes(), testcase) {
+			t.Fatal("roundtrip failed")
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	golden, err := os.ReadFile("testcases/dump.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump := testcaseNode.Dump(); dump != string(golden) {
+		t.Fatalf("dump does not match golden file:\n%s", dump)
+	}
+
+	root, _ := NewNode("player")
+	root.SetAttribute("id", "1")
+	stats, _ := root.NewNode("stats")
+	stats.NewNodeWithValue("hp", int32(5))
+	const expected = "player [void] id=\"1\"\n  stats [void]\n    hp [s32] = 5\n"
+	if dump := root.Dump(); dump != expected {
+		t.Fatalf("unexpected dump:\n%s", dump)
+	}
+}
+
+func TestSectionLayout(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.SetAttribute("a", "b")
+	prop.Root.NewNodeWithValue("v", int32(1))
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+
+	metaSize := int(binary.BigEndian.Uint32(data[4:]))
+	offset := 8 + metaSize
+	if metaSize%4 != 0 {
+		t.Fatal("metadata is not padded")
+	}
+	bodySize := int(binary.BigEndian.Uint32(data[offset:]))
+	if offset+4+bodySize != len(data) {
+		t.Fatalf("databody size %d does not precede the databody", bodySize)
+	}
+	// the attribute's length, value, and padding, followed by the value of v
+	expected := []byte{0, 0, 0, 2, 'b', 0, 0, 0, 0, 0, 0, 1}
+	if !bytes.Equal(data[offset+4:], expected) {
+		t.Fatalf("unexpected databody: %v", data[offset+4:])
+	}
+}
+
+func TestSetValueJSON(t *testing.T) {
+	node, _ := NewNode("test")
+	testcases := []struct {
+		nt       *NodeType
+		raw      string
+		expected any
+	}{
+		{S32Node, "5", int32(5)},
+		{S32Node, "[1, 2, 3]", []int32{1, 2, 3}},
+		{U8Node, "[1, 2]", []uint8{1, 2}},
+		{StrNode, `"abc"`, "abc"},
+		{BoolNode, "true", BoolValue(true)},
+		{Vec2FloatNode, "[1.5, 2]", [2]float32{1.5, 2}},
+		{Vec2FloatNode, "[[1.5, 2], [3, 4]]", [][2]float32{{1.5, 2}, {3, 4}}},
+		{BinNode, `"dead"`, BinValue{0xDE, 0xAD}},
+		{BinNode, `"3q0="`, BinValue{0xDE, 0xAD}},
+		{IPv4Node, `"10.0.0.1"`, net.IPv4(10, 0, 0, 1)},
+	}
+	for _, testcase := range testcases {
+		if err := node.SetValueJSON(testcase.nt, json.RawMessage(testcase.raw)); err != nil {
+			t.Fatalf("%s: %v", testcase.raw, err)
+		}
+		if node.Type() != testcase.nt || !reflect.DeepEqual(node.Value(), testcase.expected) {
+			t.Fatalf("%s: unexpected value: %#v", testcase.raw, node.Value())
+		}
+	}
+
+	for _, raw := range []string{`"5"`, "[1, 2, 3]", "null", "1.5"} {
+		if err := node.SetValueJSON(Vec2S8Node, json.RawMessage(raw)); err == nil {
+			t.Fatalf("%s: invalid value was accepted", raw)
+		}
+	}
+	if err := node.SetValueJSON(StrNode, json.RawMessage(`["a"]`)); err == nil {
+		t.Fatal("string array was accepted")
+	}
+	if err := node.SetValueJSON(S32Node, json.RawMessage("null")); err == nil {
+		t.Fatal("null was accepted")
+	}
+}
+
+func TestStringEncodings(t *testing.T) {
+	prop, _ := NewProperty("root")
+	str, _ := prop.Root.NewNodeWithValue("str", "テスト")
+	prop.Root.NewNodeWithValue("s32", int32(1))
+	prop.Settings.Encoding = EncodingSJIS
+
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop.Settings.Format = format
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+
+		read := &Property{}
+		read.Settings.RecordStringEncodings = true
+		if err := read.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if str := read.Root.SearchChild("str"); read.StringEncoding(str) != EncodingSJIS {
+			t.Fatalf("%d: unexpected string encoding: %v", format, read.StringEncoding(str))
+		}
+		if read.StringEncoding(read.Root.SearchChild("s32")) != nil {
+			t.Fatal("encoding was recorded for non-string node")
+		}
+	}
+
+	prop.Settings.Encoding = EncodingUTF8
+	prop.Settings.RecordStringEncodings = true
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if e := prop.StringEncoding(prop.Root.SearchChild("str")); e != EncodingNone {
+		t.Fatalf("unexpected string encoding: %v", e)
+	}
+	if prop.StringEncoding(str) != nil {
+		t.Fatal("encoding of previous read was not cleared")
+	}
+}
+
+func TestFromNode(t *testing.T) {
+	root, _ := NewNode("root")
+	child, _ := root.NewNodeWithValue("child", int32(1))
+
+	prop, err := FromNode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root != root || !reflect.DeepEqual(prop.Settings, PropertySettings{}) {
+		t.Fatal("unexpected property")
+	}
+	if _, err := FromNode(child); err == nil {
+		t.Fatal("node with parent was accepted")
+	}
+	if _, err := FromNode(nil); err == nil {
+		t.Fatal("nil node was accepted")
+	}
+}
+
+func TestEmptyArray(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("arr", []uint32{})
+
+	formats := []PropertyFormat{FormatBinary, FormatXML, FormatBinary, FormatPrettyXML}
+	for _, format := range formats {
+		prop.Settings.Format = format
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+
+		arr := prop.Root.SearchChild("arr")
+		if arr.Type() != U32Node || !arr.IsArray() || arr.Value() == nil || arr.ArrayLength() != 0 {
+			t.Fatalf("%d: empty array was not preserved", format)
+		}
+	}
+
+	if err := prop.Read(strings.NewReader(`<root><arr __type="u32" __count="0"> </arr></root>`)); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root.SearchChild("arr").ArrayLength() != 0 {
+		t.Fatal("empty array containing whitespace was not read")
+	}
+}
+
+func TestConvertType(t *testing.T) {
+	testcases := []struct {
+		value    any
+		nt       *NodeType
+		expected any
+	}{
+		{int16(-5), S32Node, int32(-5)},
+		{uint8(200), S16Node, int16(200)},
+		{uint32(7), TimeNode, TimeValue(7)},
+		{int32(16777216), FloatNode, float32(16777216)},
+		{float64(-3), S8Node, int8(-3)},
+		{float32(1.5), DoubleNode, float64(1.5)},
+		{[]int8{1, -2}, DoubleNode, []float64{1, -2}},
+		{[2]uint8{1, 2}, Vec2S32Node, [2]int32{1, 2}},
+	}
+	for _, testcase := range testcases {
+		node, _ := NewNodeWithValue("test", testcase.value)
+		if err := node.ConvertType(testcase.nt); err != nil {
+			t.Fatalf("%v: %v", testcase.value, err)
+		}
+		if node.Type() != testcase.nt || !reflect.DeepEqual(node.Value(), testcase.expected) {
+			t.Fatalf("%v: unexpected value: %#v", testcase.value, node.Value())
+		}
+	}
+
+	// vectors that were read from a document
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<v __type="2u8" __count="2">1 2 3 4</v>`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Root.ConvertType(Vec2FloatNode); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prop.Root.Value(), [][2]float32{{1, 2}, {3, 4}}) {
+		t.Fatalf("unexpected value: %#v", prop.Root.Value())
+	}
+
+	invalid := []struct {
+		value any
+		nt    *NodeType
+	}{
+		{int32(300), S8Node},
+		{int8(-1), U64Node},
+		{uint64(math.MaxUint64), S64Node},
+		{int32(16777217), FloatNode},
+		{1.5, S32Node},
+		{float64(0.1), FloatNode},
+		{[]int32{1, 1000}, U8Node},
+		{int32(1), Vec2S32Node},
+		{"1", S32Node},
+		{int32(1), BoolNode},
+	}
+	for _, testcase := range invalid {
+		node, _ := NewNodeWithValue("test", testcase.value)
+		if err := node.ConvertType(testcase.nt); err == nil {
+			t.Fatalf("%v: invalid conversion to %s was accepted", testcase.value, testcase.nt.Name())
+		}
+		if !reflect.DeepEqual(node.Value(), testcase.value) {
+			t.Fatalf("%v: value was modified", testcase.value)
+		}
+	}
+}
+
+func TestIgnoreEncodingChecksum(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("str", "テスト")
+	prop.Settings.Encoding = EncodingSJIS
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+
+	testcases := []struct {
+		encoding, checksum byte
+		expected           *Encoding
+	}{
+		{data[2], 0, EncodingSJIS},
+		{data[2], data[2], EncodingSJIS},
+		{7 << 5, 0, EncodingNone},
+	}
+	for _, testcase := range testcases {
+		data[2], data[3] = testcase.encoding, testcase.checksum
+
+		read := &Property{}
+		if err := read.Read(bytes.NewReader(data)); err == nil {
+			t.Fatal("invalid checksum was accepted")
+		}
+		read.Settings.IgnoreEncodingChecksum = true
+		if err := read.Read(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if read.Settings.Encoding != testcase.expected {
+			t.Fatalf("unexpected encoding: %v", read.Settings.Encoding)
+		}
+	}
+}
+
+func TestArrayAs(t *testing.T) {
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(testcaseBinary)); err != nil {
+		t.Fatal(err)
+	}
+
+	var node *Node
+	find := func(name string) *Node {
+		for _, c := range prop.Root.SearchChildren(name) {
+			if c.IsArray() {
+				return c
+			}
+		}
+		t.Fatalf("array %s not found", name)
+		return nil
+	}
+
+	node = find("entry_s32")
+	if len(node.ArrayElements()) != 2 {
+		t.Fatal("unexpected number of elements")
+	}
+	if s, err := ArrayAs[int32](node); err != nil || !reflect.DeepEqual(s, []int32{1, 2}) {
+		t.Fatalf("unexpected s32 array: %v %v", s, err)
+	}
+	if _, err := ArrayAs[uint32](node); err == nil {
+		t.Fatal("mismatched type was accepted")
+	}
+	if s, err := ArrayAs[float64](find("entry_double")); err != nil || !reflect.DeepEqual(s, []float64{1, 2}) {
+		t.Fatalf("unexpected double array: %v %v", s, err)
+	}
+	if s, err := ArrayAs[[2]int16](find("entry_2s16")); err != nil || !reflect.DeepEqual(s, [][2]int16{{1, 2}, {3, 4}}) {
+		t.Fatalf("unexpected 2s16 array: %v %v", s, err)
+	}
+	if _, err := ArrayAs[[2]uint16](find("entry_2s16")); err == nil {
+		t.Fatal("mismatched vector type was accepted")
+	}
+
+	node, _ = NewNodeWithValue("test", []uint8{1, 2, 3})
+	if !reflect.DeepEqual(node.ArrayElements(), []any{uint8(1), uint8(2), uint8(3)}) {
+		t.Fatal("unexpected elements")
+	}
+	if s, err := ArrayAs[uint8](node); err != nil || len(s) != 3 {
+		t.Fatalf("unexpected u8 array: %v %v", s, err)
+	}
+
+	node, _ = NewNodeWithValue("test", int32(1))
+	if node.ArrayElements() != nil {
+		t.Fatal("elements of non-array were returned")
+	}
+	if _, err := ArrayAs[int32](node); err == nil {
+		t.Fatal("non-array was accepted")
+	}
+}
+
+func TestValueFormatter(t *testing.T) {
+	names := []string{"red", "green", "blue"}
+	RegisterValueFormatter(U8Node, func(v any) string {
+		return names[v.(uint8)]
+	}, func(s string) (any, error) {
+		for i, name := range names {
+			if name == s {
+				return uint8(i), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid color: %s", s)
+	})
+	defer RegisterValueFormatter(U8Node, nil, nil)
+
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("color", uint8(2))
+	prop.Root.NewNodeWithValue("colors", []uint8{0, 1})
+	prop.Root.NewNodeWithValue("other", uint16(2))
+	prop.Settings.Format = FormatXML
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	const expected = `<?xml version="1.0"?><root><color __type="u8">blue</color>` +
+		`<colors __type="u8" __count="2">red green</colors><other __type="u16">2</other></root>`
+	if wr.String() != expected {
+		t.Fatalf("unexpected output: %s", wr.String())
+	}
+
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.ChildValue("color"); v != uint8(2) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if v := prop.Root.ChildValue("colors"); !reflect.DeepEqual(v, []any{uint8(0), uint8(1)}) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+
+	// the binary format is not affected
+	prop.Settings.Format = FormatBinary
+	wr.Reset()
+	RegisterValueFormatter(U8Node, nil, nil)
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.ChildValue("color"); v != uint8(2) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+
+	if err := prop.Read(strings.NewReader(`<color __type="u8">blue</color>`)); err == nil {
+		t.Fatal("formatter was not removed")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	prop := &Property{}
+	prop.Settings.AllowNilValues = true
+	if err := prop.Read(strings.NewReader(`<root><a __type="s32"/><b __type="s32">1</b></root>`)); err != nil {
+		t.Fatal(err)
+	}
+	prop.Root.SearchChild("a").value = nil
+	if err := prop.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	prop.Settings.Format = FormatBinary
+	if err := prop.Validate(); err == nil {
+		t.Fatal("nil value was accepted")
+	}
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err == nil {
+		t.Fatal("nil value was written")
+	}
+	if wr.Len() != 0 {
+		t.Fatalf("%d bytes were written", wr.Len())
+	}
+
+	prop.Root.SearchChild("a").SetValue(int32(0))
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Property{}).Validate(); err == nil {
+		t.Fatal("empty property was accepted")
+	}
+}
+
+func TestSetFromMap(t *testing.T) {
+	root, _ := NewNode("root")
+	err := root.SetFromMap(map[string]any{
+		"name": "test",
+		"id":   int32(5),
+		"stats": map[string]any{
+			"speed": float32(1.5),
+			"hp":    []uint16{100, 200},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `root [void]
+  id [s32] = 5
+  name [str] = "test"
+  stats [void]
+    hp [u16[2]] = [100 200]
+    speed [float] = 1.5
+`
+	if dump := root.Dump(); dump != expected {
+		t.Fatalf("unexpected tree:\n%s", dump)
+	}
+
+	node, _ := NewNode("root")
+	err = node.SetFromMap(map[string]any{
+		"valid":   int32(1),
+		"invalid": map[string]any{"value": 1},
+	})
+	if err == nil || !strings.Contains(err.Error(), "value") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(node.Children()) != 0 {
+		t.Fatal("node was modified")
+	}
+	if err := node.SetFromMap(map[string]any{"__foo": int32(1)}); err == nil {
+		t.Fatal("invalid key was accepted")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := ReadSchema(strings.NewReader(`{"avs/entry_s8": "s8", "avs/entry_bin": "binary"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := schema.Validate(testcaseNode); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	schema["avs/entry_s8"] = U8Node
+	schema["avs/missing"] = U8Node
+	if errs := schema.Validate(testcaseNode); len(errs) != 3 {
+		t.Fatalf("unexpected violations: %v", errs)
+	}
+
+	if _, err := ReadSchema(strings.NewReader(`{"avs": "foo"}`)); err == nil {
+		t.Fatal("invalid type was accepted")
+	}
+}
+
+func TestPeekRoot(t *testing.T) {
+	testcases := []struct {
+		data []byte
+		typ  *NodeType
+	}{
+		{testcaseBinary, VoidNode},
+		{testcaseBinaryLong, VoidNode},
+		{testcaseXML, VoidNode},
+		{[]byte(`<?xml version="1.0" encoding="SHIFT_JIS"?><root __type="s32">1</root>`), S32Node},
+		{[]byte(`{"name":"root","__type":"s32","value":1}`), S32Node},
+		{[]byte("\n{\"attributes\":{\"a\":\"b\"},\"children\":[{\"name\":\"c\"}],\"name\":\"root\"}"), VoidNode},
+	}
+	for i, testcase := range testcases {
+		name, typ, err := PeekRoot(bytes.NewReader(testcase.data))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if typ != testcase.typ || (name != "avs" && name != "root") {
+			t.Fatalf("%d: unexpected root: %s %s", i, name, typ.Name())
+		}
+	}
+
+	// nothing past the root node should be consumed
+	rd := bytes.NewReader(testcaseBinary)
+	if _, _, err := PeekRoot(rd); err != nil {
+		t.Fatal(err)
+	}
+	if consumed := len(testcaseBinary) - rd.Len(); consumed != 8+1+1+3 {
+		t.Fatalf("%d bytes were consumed", consumed)
+	}
+	rd = bytes.NewReader([]byte(`<root><child/></root>`))
+	if _, _, err := PeekRoot(rd); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(rd); string(rest) != "<child/></root>" {
+		t.Fatalf("unexpected remainder: %s", rest)
+	}
+
+	if _, _, err := PeekRoot(strings.NewReader("foo")); err == nil {
+		t.Fatal("invalid document was accepted")
+	}
+}
+
+func TestEncodeErrorPolicy(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("str", "テスト😀<a>")
+	prop.Settings.Encoding = EncodingSJIS
+
+	testcases := []struct {
+		policy   EncodeErrorPolicy
+		expected string
+	}{
+		{EncodeErrorFail, ""},
+		{EncodeErrorReplace, "テスト?<a>"},
+		{EncodeErrorDrop, "テスト<a>"},
+	}
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		for _, testcase := range testcases {
+			prop.Settings.Format = format
+			prop.Settings.EncodeErrorPolicy = testcase.policy
+			wr := &bytes.Buffer{}
+			err := prop.Write(wr)
+			if testcase.policy == EncodeErrorFail {
+				if err == nil {
+					t.Fatalf("%d: unrepresentable character was written", format)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			read := &Property{}
+			if err := read.Read(wr); err != nil {
+				t.Fatal(err)
+			}
+			if v := read.Root.ChildValue("str"); v != testcase.expected {
+				t.Fatalf("%d: unexpected value: %v", format, v)
+			}
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	const depth = 10000
+	doc := strings.Repeat("<a>", depth) + strings.Repeat("</a>", depth)
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(doc)); err == nil {
+		t.Fatal("max depth was not enforced")
+	}
+
+	doc = strings.Repeat("<a>", 150) + strings.Repeat("</a>", 150)
+	if err := prop.Read(strings.NewReader(doc)); err == nil {
+		t.Fatal("default max depth was not enforced")
+	}
+	prop.Settings.MaxDepth = 150
+	if err := prop.Read(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+
+	prop.Settings.Format = FormatBinary
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	if err := prop.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	prop.Settings.MaxDepth = 149
+	if err := prop.Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("max depth was not enforced")
+	}
+}
+
+func TestValueBytes(t *testing.T) {
+	values := []any{
+		int8(-1), uint16(2), int32(3), uint64(4), float32(5), BoolValue(true),
+		[3]uint8{6, 7, 8}, []int16{9, 10, 11}, "twelve", BinValue{13, 14},
+		net.IPv4(10, 0, 0, 1), []BoolValue{},
+	}
+	for _, v := range values {
+		prop, _ := NewProperty("root")
+		node, _ := prop.Root.NewNodeWithValue("v", v)
+		b, err := node.ValueBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		data := wr.Bytes()
+		metaSize := binary.BigEndian.Uint32(data[4:])
+		databody := data[8+metaSize+4:]
+
+		if len(databody) != (len(b)+3)&^3 || !bytes.Equal(databody[:len(b)], b) {
+			t.Fatalf("%v: value bytes %v do not match databody %v", v, b, databody)
+		}
+	}
+
+	node, _ := NewNode("void")
+	if _, err := node.ValueBytes(); err == nil {
+		t.Fatal("value of void node was returned")
+	}
+}
+
+func TestSearchTrimsWhitespace(t *testing.T) {
+	node, _ := NewNode("root")
+	child, _ := node.NewNodeWithValue("foo", int32(1))
+	node.SetAttribute("bar", "baz")
+
+	if node.SearchChild(" foo ") != child {
+		t.Fatal("child not found")
+	}
+	if len(node.SearchChildren("\tfoo\n")) != 1 {
+		t.Fatal("children not found")
+	}
+	if v := node.ChildValue(" foo"); v != int32(1) {
+		t.Fatal("unexpected child value:", v)
+	}
+	if node.SearchAttribute("bar ") == nil || node.AttributeValue(" bar") != "baz" {
+		t.Fatal("attribute not found")
+	}
+	if node.SearchChild("f oo") != nil {
+		t.Fatal("name with inner whitespace matched")
+	}
+}
+
+func TestReadNumericArray(t *testing.T) {
+	values := []any{
+		[]int8{-1, 2}, []uint8{3, 4}, []int16{-5, 6}, []uint16{7, 8},
+		[]int32{-9, 10}, []uint32{11, 12}, []int64{-13, 14}, []uint64{15, 16},
+		[]TimeValue{17, 18}, []float32{19.5, 20}, []float64{-21.5, 22},
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, v := range values {
+			prop, _ := NewProperty("root")
+			prop.Settings.ByteOrder = order
+			prop.Root.NewNodeWithValue("array", v)
+			wr := &bytes.Buffer{}
+			if err := prop.Write(wr); err != nil {
+				t.Fatal(err)
+			}
+
+			prop.Settings.ByteOrder = order
+			if err := prop.Read(wr); err != nil {
+				t.Fatal(err)
+			}
+			if got := prop.Root.ChildValue("array"); !reflect.DeepEqual(got, v) {
+				t.Fatalf("expected %#v, got %#v", v, got)
+			}
+
+			wr.Reset()
+			prop.Settings.Format = FormatXML
+			if err := prop.Write(wr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	build := func() *Property {
+		prop, _ := NewProperty("root")
+		a, _ := prop.Root.NewNode("a")
+		a.NewNode("empty")
+		a.NewNodeWithValue("value", int32(1))
+		b, _ := prop.Root.NewNode("b")
+		c, _ := b.NewNode("c")
+		c.NewNode("d")
+		b.NewNode("e")
+		f, _ := prop.Root.NewNode("f")
+		f.SetAttribute("g", "h")
+		return prop
+	}
+
+	prop := build()
+	prop.Compact()
+	if s := prop.Root.Dump(); s != "root [void]\n  a [void]\n    value [s32] = 1\n  f [void] g=\"h\"\n" {
+		t.Fatalf("unexpected tree:\n%s", s)
+	}
+
+	prop = build()
+	prop.CompactAll()
+	if s := prop.Root.Dump(); s != "root [void]\n  a [void]\n    value [s32] = 1\n" {
+		t.Fatalf("unexpected tree:\n%s", s)
+	}
+
+	prop, _ = NewProperty("root")
+	prop.Root.NewNode("empty")
+	prop.Compact()
+	if prop.Root == nil || len(prop.Root.Children()) != 0 {
+		t.Fatal("root was not compacted correctly")
+	}
+}
+
+func TestAllowMissingDatabody(t *testing.T) {
+	prop, _ := NewProperty("root")
+	node, _ := prop.Root.NewNodeWithValue("value", int32(1))
+	node.SetAttribute("a", "b")
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	metadata := data[:8+binary.BigEndian.Uint32(data[4:])]
+
+	if err := prop.Read(bytes.NewReader(metadata)); err == nil {
+		t.Fatal("document without databody was read")
+	}
+
+	prop.Settings.AllowMissingDatabody = true
+	if err := prop.Read(bytes.NewReader(metadata)); err != nil {
+		t.Fatal(err)
+	}
+	node = prop.Root.SearchChild("value")
+	if node == nil || node.Type() != S32Node || node.Value() != nil || node.SearchAttribute("a") == nil {
+		t.Fatal("metadata was not read correctly")
+	}
+
+	// a truncated section size is still an error
+	if err := prop.Read(bytes.NewReader(data[:len(metadata)+2])); err == nil {
+		t.Fatal("truncated document was read")
+	}
+}
+
+func TestNewNodeFromStrings(t *testing.T) {
+	tests := []struct {
+		typeName, value string
+		expected        string
+	}{
+		{"s32", "-1", "v [s32] = -1"},
+		{"u8", "1 2  3", "v [u8[3]] = [1 2 3]"},
+		{"ip4", "127.0.0.1", "v [ip4] = 127.0.0.1"},
+		{"3s16", "1 2 3", "v [3s16] = [1 2 3]"},
+		{"2u8", "1 2 3 4", "v [2u8[2]] = [[1 2] [3 4]]"},
+		{"bool", "1", "v [bool] = 1"},
+		{"str", " a b ", "v [str] = \" a b \""},
+		{"bin", "0102", "v [bin] = 0102"},
+		{"void", "", "v [void]"},
+	}
+	for _, test := range tests {
+		node, err := NewNodeFromStrings("v", test.typeName, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := strings.TrimSuffix(node.Dump(), "\n"); s != test.expected {
+			t.Fatalf("expected %q, got %q", test.expected, s)
+		}
+	}
+
+	invalid := [][2]string{
+		{"s33", "1"}, {"s8", "128"}, {"s32", ""}, {"2s32", "1 2 3"},
+		{"ip4", "1.2.3"}, {"bin", "0g"}, {"void", "1"},
+	}
+	for _, test := range invalid {
+		if _, err := NewNodeFromStrings("v", test[0], test[1]); err == nil {
+			t.Fatalf("%s value %q was accepted", test[0], test[1])
+		} else if strings.Count(err.Error(), "avsproperty:") != 1 {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+}
+
+func TestMultilineAttributes(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatPrettyXML
+	prop.Settings.MultilineAttributes = 2
+	node, _ := prop.Root.NewNodeWithValue("node", int32(1))
+	for _, k := range []string{"a", "b", "c"} {
+		node.SetAttribute(k, k)
+	}
+	prop.Root.SetAttribute("d", "d")
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0"?>
+<root d="d">
+    <node
+        __type="s32"
+        a="a"
+        b="b"
+        c="c">1</node>
+</root>
+`
+	if s := wr.String(); s != expected {
+		t.Fatalf("unexpected output:\n%s", s)
+	}
+
+	dump := prop.Root.Dump()
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := prop.Root.Dump(); s != dump {
+		t.Fatalf("expected:\n%s\ngot:\n%s", dump, s)
+	}
+}
+
+func TestUseCDATA(t *testing.T) {
+	values := map[string]bool{
+		"<a href=\"x&y\">&&</a> <<<": true,
+		"plain":                      false,
+		"a]]>b <":                    false,
+		"a\r\nb <":                   false,
+	}
+	for v, cdata := range values {
+		prop, _ := NewProperty("root")
+		prop.Settings.Format = FormatXML
+		prop.Settings.UseCDATA = true
+		prop.Root.NewNodeWithValue("s", v)
+
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(wr.String(), "<![CDATA[") != cdata {
+			t.Fatalf("%q: unexpected output: %s", v, wr)
+		}
+
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if s := prop.Root.SearchChild("s").StringValue(); s != v {
+			t.Fatalf("expected %q, got %q", v, s)
+		}
+	}
+}
+
+func TestCoalesceScalars(t *testing.T) {
+	node, _ := NewNode("root")
+	node.NewNodeWithValue("other", int32(0))
+	for i := 1; i <= 5; i++ {
+		node.NewNodeWithValue("v", int32(i))
+	}
+	node.NewNodeWithValue("other", int32(6))
+	node.NewNodeWithValue("v", int32(7))
+
+	if err := node.CoalesceScalars("v"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `root [void]
+  other [s32] = 0
+  v [s32[5]] = [1 2 3 4 5]
+  other [s32] = 6
+  v [s32[1]] = [7]
+`
+	if s := node.Dump(); s != expected {
+		t.Fatalf("unexpected tree:\n%s", s)
+	}
+	if _, err := ArrayAs[int32](node.SearchChild("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	node, _ = NewNode("root")
+	node.NewNodeWithValue("v", int32(1))
+	node.NewNodeWithValue("v", uint32(2))
+	if err := node.CoalesceScalars("v"); err == nil {
+		t.Fatal("values of different types were coalesced")
+	}
+	if len(node.Children()) != 2 || node.Children()[0].IsArray() {
+		t.Fatal("node was modified")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var events []string
+	trace := &Trace{
+		OnHeader: func() {
+			events = append(events, "header")
+		},
+		OnMetadataDone: func(nodeCount int) {
+			events = append(events, "metadata "+strconv.Itoa(nodeCount))
+		},
+		OnDatabodyDone: func(bytes int) {
+			events = append(events, "databody "+strconv.Itoa(bytes))
+		},
+	}
+
+	prop, _ := NewProperty("root")
+	prop.Settings.Trace = trace
+	prop.Root.NewNodeWithValue("a", int32(1))
+	prop.Root.NewNodeWithValue("b", "bc")
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"header", "metadata 3", "databody 12"}
+	expected = append(expected, expected...)
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatal("unexpected events:", events)
+	}
+
+	// callbacks are optional
+	prop.Settings.Trace = &Trace{}
+	if err := prop.Write(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetValueAuto(t *testing.T) {
+	node, _ := NewNodeWithValue("v", int16(0))
+	if err := node.SetValueAuto(" -2 "); err != nil || node.Value() != int16(-2) {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+	if err := node.SetValueAuto("1 2"); err != nil || node.ArrayLength() != 2 {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+	// array nodes keep their array value
+	if err := node.SetValueAuto("3"); err != nil || !node.IsArray() || node.ArrayLength() != 1 {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+	if err := node.SetValueAuto("x"); err == nil {
+		t.Fatal("invalid value was accepted")
+	} else if node.ArrayLength() != 1 {
+		t.Fatal("node was modified")
+	}
+	// non-string values are assigned as usual
+	if err := node.SetValueAuto(uint8(4)); err != nil || node.Type() != U8Node {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+
+	node, _ = NewNodeWithValue("v", "a")
+	if err := node.SetValueAuto("1"); err != nil || node.Value() != "1" {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+
+	node, _ = NewNode("v")
+	if err := node.SetValueAuto("1"); err != nil || node.Type() != StrNode {
+		t.Fatal("unexpected value:", node.Value(), err)
+	}
+}
+
+func TestStableID(t *testing.T) {
+	build := func(extra ...string) (*Node, *Node) {
+		root, _ := NewNode("root")
+		for _, name := range extra {
+			root.NewNode(name)
+		}
+		root.NewNode("a")
+		b, _ := root.NewNode("b")
+		root.NewNode("b")
+		c, _ := b.NewNodeWithValue("c", int32(1))
+		return root, c
+	}
+	position := func(n *Node) int {
+		for i, c := range n.parent.parent.children {
+			if c == n.parent {
+				return i
+			}
+		}
+		return -1
+	}
+
+	root1, c1 := build()
+	root2, c2 := build("x", "y")
+	if c1.StableID() != c2.StableID() {
+		t.Fatal("unrelated siblings changed the id")
+	}
+	if position(c1) == position(c2) {
+		t.Fatal("positions should differ")
+	}
+	if root1.StableID() != root2.StableID() {
+		t.Fatal("root ids differ")
+	}
+
+	// same-named siblings are disambiguated
+	b := root1.SearchChildren("b")
+	if b[0].StableID() == b[1].StableID() {
+		t.Fatal("same-named siblings have the same id")
+	}
+	_, c3 := build("b")
+	if c1.StableID() == c3.StableID() {
+		t.Fatal("same-named sibling did not change the id")
+	}
+
+	// the type is part of the id
+	id := c1.StableID()
+	c1.SetValue(uint32(1))
+	if c1.StableID() == id {
+		t.Fatal("type did not change the id")
+	}
+}
+
+func TestReadNumericTypeId(t *testing.T) {
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<x __type="6">5</x>`)); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root.Type() != S32Node || prop.Root.Value() != int32(5) {
+		t.Fatal("unexpected node:", prop.Root.Dump())
+	}
+
+	if _, typ, err := PeekRoot(strings.NewReader(`<x __type="11"/>`)); err != nil || typ != StrNode {
+		t.Fatal("unexpected type:", typ, err)
+	}
+
+	for _, id := range []string{"0", "254", "300", "-1"} {
+		if err := prop.Read(strings.NewReader(`<x __type="` + id + `">5</x>`)); err == nil {
+			t.Fatal("invalid type id was accepted:", id)
+		}
+	}
+}
+
+func TestSwapChildren(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	for _, name := range []string{"a", "b", "c"} {
+		prop.Root.NewNode(name)
+	}
+
+	if err := prop.Root.SwapChildren(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Root.SwapChildren(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range [][2]int{{-1, 0}, {0, 3}} {
+		if err := prop.Root.SwapChildren(i[0], i[1]); err == nil {
+			t.Fatal("out of range indices were accepted:", i)
+		}
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); s != `<?xml version="1.0"?><root><b></b><c></c><a></a></root>` {
+		t.Fatal("unexpected output:", s)
+	}
+	for _, c := range prop.Root.Children() {
+		if c.Parent() != prop.Root {
+			t.Fatal("parent was modified")
+		}
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.BufferSize = 16
+	prop.Root.NewNodeWithValue("v", strings.Repeat("a", 100))
+
+	filename := t.TempDir() + "/test.bin"
+	if err := prop.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if len(prop.Root.SearchChild("v").StringValue()) != 100 {
+		t.Fatal("unexpected value")
+	}
+}
+
+func TestTypeHints(t *testing.T) {
+	src := `<root><a>-1</a><b __count="2">1 2</b><c>text</c><d></d><e __type="u8">3</e></root>`
+	prop := &Property{}
+	prop.Settings.TypeHints = Schema{
+		"root/a": S32Node,
+		"root/b": U16Node,
+		"root/d": BinNode,
+		"root/e": S64Node,
+	}
+	if err := prop.Read(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `root [void]
+  a [s32] = -1
+  b [u16[2]] = [1 2]
+  c [str] = "text"
+  d [bin] = 
+  e [u8] = 3
+`
+	if s := prop.Root.Dump(); s != expected {
+		t.Fatalf("unexpected tree:\n%s", s)
+	}
+
+	prop.Settings.TypeHints = Schema{"root/a": IPv4Node}
+	if err := prop.Read(strings.NewReader(src)); err == nil {
+		t.Fatal("value that does not match hint was accepted")
+	}
+}
+
+func TestBitset16(t *testing.T) {
+	patterns := map[[2]byte][16]BoolValue{
+		{0x00, 0x00}: {},
+		{0xFF, 0xFF}: {true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true},
+		{0xAA, 0xAA}: {true, false, true, false, true, false, true, false, true, false, true, false, true, false, true, false},
+		{0x80, 0x01}: {0: true, 15: true},
+	}
+	for packed, bools := range patterns {
+		prop, _ := NewProperty("root")
+		node, _ := prop.Root.NewNodeWithValue("bits", bools)
+		if err := node.ConvertType(Bitset16Node); err != nil {
+			t.Fatal(err)
+		}
+		b, err := node.ValueBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, packed[:]) {
+			t.Fatalf("%v: expected %x, got %x", bools, packed, b)
+		}
+
+		for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+			prop.Settings.Format = format
+			wr := &bytes.Buffer{}
+			if err := prop.Write(wr); err != nil {
+				t.Fatal(err)
+			}
+			if err := prop.Read(wr); err != nil {
+				t.Fatal(err)
+			}
+			node := prop.Root.SearchChild("bits")
+			if node.Type() != Bitset16Node || fmt.Sprint(node.Value()) != fmt.Sprint(bools) {
+				t.Fatal("unexpected node:", node.Dump())
+			}
+		}
+	}
+
+	node, _ := NewNodeWithValue("bits", [16]BoolValue{})
+	if node.Type() != Vec16BoolNode {
+		t.Fatal("unexpected type:", node.Type().Name())
+	}
+}
+
+func TestNodeByStableID(t *testing.T) {
+	prop, _ := NewProperty("root")
+	a, _ := prop.Root.NewNode("a")
+	a.NewNode("x")
+	target, _ := a.NewNodeWithValue("b", int32(1))
+	id := target.StableID()
+
+	// siblings with other names do not affect the id
+	a.NewNode("y")
+	if err := a.SwapChildren(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if prop.NodeByStableID(id) != target {
+		t.Fatal("node was not found")
+	}
+	if prop.NodeByStableID(prop.Root.StableID()) != prop.Root {
+		t.Fatal("root was not found")
+	}
+
+	target.SetValue("1")
+	if prop.NodeByStableID(id) != nil {
+		t.Fatal("node with different type was found")
+	}
+	if (&Property{}).NodeByStableID(id) != nil {
+		t.Fatal("node was found in empty property")
+	}
+}
+
+func TestLazyStrings(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.SetEncoding(EncodingSJIS)
+	prop.Root.NewNodeWithValue("a", "日本語")
+	prop.Root.NewNodeWithValue("b", "テスト")
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	data := wr.Bytes()
+
+	prop.Settings.LazyStrings = true
+	if err := prop.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	a, b := prop.Root.SearchChild("a"), prop.Root.SearchChild("b")
+	if _, ok := a.value.(*lazyString); !ok {
+		t.Fatal("value was decoded eagerly")
+	}
+	if s := a.StringValue(); s != "日本語" {
+		t.Fatal("unexpected value:", s)
+	}
+	if s, ok := a.value.(string); !ok || s != "日本語" {
+		t.Fatal("decoded value was not memoized")
+	}
+	if v := b.Value(); v != "テスト" {
+		t.Fatal("unexpected value:", v)
+	}
+
+	if err := prop.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	dump := `root [void]
+  a [str] = "日本語"
+  b [str] = "テスト"
+`
+	if s := prop.Root.Dump(); s != dump {
+		t.Fatal("unexpected tree:", s)
+	}
+
+	// lazily decoded values can be written directly
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		if err := prop.Read(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		prop.Settings.Format = format
+		out := &bytes.Buffer{}
+		if err := prop.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		if err := prop.Read(out); err != nil {
+			t.Fatal(err)
+		}
+		if s := prop.Root.Dump(); s != dump {
+			t.Fatal("unexpected tree:", s)
+		}
+	}
+}
+
+func TestNormalizeEncoding(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.SetEncoding(EncodingSJIS)
+	node, _ := prop.Root.NewNodeWithValue("s", "日本語")
+	node.SetAttribute("a", "テスト")
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prop.NormalizeEncoding(nil); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Encoding() != EncodingUTF8 {
+		t.Fatal("unexpected encoding:", prop.Encoding())
+	}
+	prop.Settings.Format = FormatXML
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?><root><s __type="str" a="テスト">日本語</s></root>`
+	if s := wr.String(); s != expected || !utf8.Valid(wr.Bytes()) {
+		t.Fatal("unexpected output:", s)
+	}
+
+	// undecoded strings
+	sjis, _ := EncodingSJIS.encoder().Bytes([]byte("日本語"))
+	prop, _ = NewProperty("root")
+	prop.Root.NewNodeWithValue("s", string(sjis))
+	if err := prop.NormalizeEncoding(nil); err == nil {
+		t.Fatal("invalid UTF-8 was accepted")
+	} else if prop.Encoding() != EncodingNone || prop.Root.ChildValue("s") != string(sjis) {
+		t.Fatal("property was modified")
+	}
+	if err := prop.NormalizeEncoding(EncodingSJIS); err != nil {
+		t.Fatal(err)
+	}
+	if s := prop.Root.ChildValue("s"); s != "日本語" {
+		t.Fatal("unexpected value:", s)
+	}
+}
+
+func TestCompactSectionSizes(t *testing.T) {
+	prop, _ := NewProperty("root")
+	node, _ := prop.Root.NewNodeWithValue("s", "value")
+	if !prop.IsCompactEligible() {
+		t.Fatal("small property is not eligible")
+	}
+
+	prop.Settings.Compact = true
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	if magic := binary.BigEndian.Uint16(data); magic != binaryMagic|binaryMagicCompactFlag {
+		t.Fatalf("unexpected magic number: %x", magic)
+	}
+	if size := binary.BigEndian.Uint16(data[4:]); int(size) != len(data)-4-2-2-12 {
+		t.Fatal("unexpected metadata size:", size)
+	}
+
+	if name, _, err := PeekRoot(bytes.NewReader(data)); err != nil || name != "root" {
+		t.Fatal("unexpected root:", name, err)
+	}
+	prop.Settings.Compact = false
+	if err := prop.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !prop.Settings.Compact || prop.Root.ChildValue("s") != "value" {
+		t.Fatal("compact property was not read correctly")
+	}
+
+	node = prop.Root.SearchChild("s")
+	node.SetValue(strings.Repeat("a", maxCompactSectionSize))
+	if prop.IsCompactEligible() {
+		t.Fatal("large property is eligible")
+	}
+	wr.Reset()
+	if err := prop.Write(wr); err == nil || wr.Len() != 0 {
+		t.Fatal("large property was written")
+	}
+	if (&Property{}).IsCompactEligible() {
+		t.Fatal("empty property is eligible")
+	}
+
+	// errors other than the size are reported as they are
+	node.SetValue("テスト")
+	prop.Settings.Encoding = EncodingASCII
+	if err := prop.Write(io.Discard); err == nil || strings.Contains(err.Error(), "too large") {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestCut(t *testing.T) {
+	root, _ := NewNode("root")
+	a, _ := root.NewNode("a")
+	b, _ := root.NewNode("b")
+	c, _ := a.NewNodeWithValue("c", int32(1))
+	a.NewNode("d")
+
+	if err := c.Cut(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Parent() != nil || len(a.Children()) != 1 || a.Children()[0].Name().String() != "d" {
+		t.Fatal("node was not cut correctly")
+	}
+	if err := b.AppendChild(c); err != nil {
+		t.Fatal(err)
+	}
+	if b.SearchChild("c") != c || c.Parent() != b {
+		t.Fatal("node was not appended correctly")
+	}
+	if err := root.Cut(); err == nil {
+		t.Fatal("root was cut")
+	}
+}
+
+func TestEqualApprox(t *testing.T) {
+	build := func(f float32, d float64, ds []float64) *Node {
+		root, _ := NewNode("root")
+		root.SetAttribute("a", "b")
+		root.NewNodeWithValue("f", f)
+		root.NewNodeWithValue("d", d)
+		root.NewNodeWithValue("ds", ds)
+		root.NewNodeWithValue("v", [2]int32{1, 2})
+		return root
+	}
+
+	a := build(1, 0.1, []float64{1, 2})
+	b := build(math.Nextafter32(1, 2), math.Nextafter(0.1, 1), []float64{1, math.Nextafter(2, 3)})
+	if a.EqualApprox(b, 0) {
+		t.Fatal("values are equal without tolerance")
+	}
+	if !a.EqualApprox(b, 1e-6) {
+		t.Fatal("values are not equal within tolerance")
+	}
+	if a.EqualApprox(build(1.1, 0.1, []float64{1, 2}), 1e-6) {
+		t.Fatal("values are equal within tolerance")
+	}
+
+	// values that were read from a document
+	prop := NodeProperty(a)
+	prop.Settings.Format = FormatXML
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if !prop.Root.EqualApprox(b, 1e-6) {
+		t.Fatal("values that were read are not equal")
+	}
+
+	c := build(1, 0.1, []float64{1, 2})
+	c.SearchChild("v").SetValue([2]int32{1, 3})
+	if a.EqualApprox(c, 1) {
+		t.Fatal("integer values are compared approximately")
+	}
+	c = build(1, 0.1, []float64{1, 2})
+	c.SetAttribute("a", "c")
+	if a.EqualApprox(c, 1) {
+		t.Fatal("attributes are not compared")
+	}
+}
+
+func TestRemoveChild(t *testing.T) {
+	root, _ := NewNode("root")
+	a, _ := root.NewNode("a")
+	b, _ := root.NewNodeWithValue("b", int32(1))
+	c, _ := root.NewNode("c")
+
+	if err := root.RemoveChild(b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Parent() != nil || len(root.Children()) != 2 || root.Children()[1] != c {
+		t.Fatal("child was not removed correctly")
+	}
+	if err := root.RemoveChild(b); err == nil {
+		t.Fatal("node that is not a child was removed")
+	}
+
+	for _, i := range []int{-1, 2} {
+		if err := root.RemoveChildAt(i); err == nil {
+			t.Fatal("out of range index was accepted:", i)
+		}
+	}
+	if err := root.RemoveChildAt(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.RemoveChildAt(0); err != nil {
+		t.Fatal(err)
+	}
+	if a.Parent() != nil || c.Parent() != nil || len(root.Children()) != 0 || root.Type() != VoidNode {
+		t.Fatal("children were not removed correctly")
+	}
+	if err := root.AppendChild(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabodyLayout(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.SetAttribute("a", "attr")
+	prop.Root.NewNodeWithValue("u8", uint8(1))
+	prop.Root.NewNodeWithValue("s32", int32(2))
+	prop.Root.NewNodeWithValue("u16", uint16(3))
+	prop.Root.NewNodeWithValue("s8", int8(4))
+	prop.Root.NewNodeWithValue("str", "five")
+	prop.Root.NewNodeWithValue("array", []uint8{6, 7})
+	prop.Root.NewNodeWithValue("u64", uint64(8))
+
+	layout, err := prop.DatabodyLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		path           string
+		offset, length int
+	}{
+		{"root@a", 0, 12},
+		{"root/u8", 12, 1},
+		{"root/s32", 16, 4},
+		{"root/u16", 20, 2},
+		{"root/s8", 13, 1},
+		{"root/str", 24, 12},
+		{"root/array", 36, 8},
+		{"root/u64", 44, 8},
+	}
+	if len(layout) != len(expected) {
+		t.Fatal("unexpected layout:", layout)
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	databody := data[8+binary.BigEndian.Uint32(data[4:])+4:]
+	for i, l := range layout {
+		e := expected[i]
+		if l.Path != e.path || l.Offset != e.offset || l.Length != e.length {
+			t.Fatalf("expected %v, got %v", e, l)
+		}
+		if !bytes.Equal(l.Bytes, databody[l.Offset:l.Offset+l.Length]) {
+			t.Fatalf("%s: bytes do not match databody", l.Path)
+		}
+	}
+
+	node, _ := prop.Root.NewNode("nil")
+	node.nodeType = S32Node
+	if _, err := prop.DatabodyLayout(); err == nil {
+		t.Fatal("nil value was accepted")
+	}
+}
+
+func TestAttributeOnlyNodes(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.SetAttribute("a", "1")
+	prop.Root.SetAttribute("b", "2")
+	leaf, _ := prop.Root.NewNode("leaf")
+	leaf.SetAttribute("c", "3")
+	prop.Root.NewNodeWithValue("value", int32(4))
+	expected := prop.Root.Dump()
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	databody := data[8+binary.BigEndian.Uint32(data[4:])+4:]
+	// only the attribute values of the void nodes are written
+	if !bytes.Equal(databody, []byte{
+		0, 0, 0, 2, '1', 0, 0, 0,
+		0, 0, 0, 2, '2', 0, 0, 0,
+		0, 0, 0, 2, '3', 0, 0, 0,
+		0, 0, 0, 4,
+	}) {
+		t.Fatalf("unexpected databody: %v", databody)
+	}
+
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML, FormatPrettyXML} {
+		prop.Settings.Format = format
+		wr.Reset()
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if s := prop.Root.Dump(); s != expected {
+			t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+		}
+	}
+
+	// a root without children
+	prop, _ = NewProperty("root")
+	prop.Root.SetAttribute("a", "1")
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop.Settings.Format = format
+		wr.Reset()
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if s := prop.Root.Dump(); s != "root [void] a=\"1\"\n" {
+			t.Fatal("unexpected tree:", s)
+		}
+	}
+}
+
+func TestSelectPath(t *testing.T) {
+	prop, _ := NewProperty("root")
+	info, _ := prop.Root.NewNode("info")
+	prop.Root.NewNode("info")
+	version, _ := info.NewNodeWithValue("version", int32(1))
+
+	if path := version.Path(); path != "root/info/version" {
+		t.Fatal("unexpected path:", path)
+	}
+	if path := prop.Root.Path(); path != "root" {
+		t.Fatal("unexpected path:", path)
+	}
+
+	for path, expected := range map[string]*Node{
+		"info/version":    version,
+		"/info//version/": version,
+		"info":            info,
+		"":                prop.Root,
+		"/":               prop.Root,
+		"info/missing":    nil,
+		"version":         nil,
+	} {
+		if n := prop.Root.SelectPath(path); n != expected {
+			t.Fatalf("%q: unexpected node: %v", path, n)
+		}
+	}
+
+	if prop.SelectPath(version.Path()) != version {
+		t.Fatal("node was not found by its path")
+	}
+	if prop.SelectPath("/root") != prop.Root || prop.SelectPath("other/info") != nil {
+		t.Fatal("root was not matched correctly")
+	}
+}
+
+func TestAssignTo(t *testing.T) {
+	node, _ := NewNodeWithValue("v", int16(-2))
+	var i int
+	if err := node.AssignTo(reflect.ValueOf(&i).Elem()); err != nil || i != -2 {
+		t.Fatal("unexpected value:", i, err)
+	}
+	var f float64
+	if err := node.AssignTo(reflect.ValueOf(&f).Elem()); err != nil || f != -2 {
+		t.Fatal("unexpected value:", f, err)
+	}
+	u := uint8(1)
+	if err := node.AssignTo(reflect.ValueOf(&u).Elem()); err == nil || u != 1 {
+		t.Fatal("lossy conversion was accepted:", u)
+	}
+
+	node, _ = NewNodeWithValue("v", float32(1.5))
+	if err := node.AssignTo(reflect.ValueOf(&i).Elem()); err == nil {
+		t.Fatal("lossy conversion was accepted")
+	}
+	if err := node.AssignTo(reflect.ValueOf(&f).Elem()); err != nil || f != 1.5 {
+		t.Fatal("unexpected value:", f, err)
+	}
+
+	// arrays and vectors that were read from a document
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	prop.Root.NewNodeWithValue("array", []uint16{1, 2, 3})
+	prop.Root.NewNodeWithValue("vectors", [][2]int8{{1, 2}, {3, 4}})
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	var ints []int
+	if err := prop.Root.SearchChild("array").AssignTo(reflect.ValueOf(&ints).Elem()); err != nil || !reflect.DeepEqual(ints, []int{1, 2, 3}) {
+		t.Fatal("unexpected value:", ints, err)
+	}
+	var vectors [][2]float32
+	if err := prop.Root.SearchChild("vectors").AssignTo(reflect.ValueOf(&vectors).Elem()); err != nil || !reflect.DeepEqual(vectors, [][2]float32{{1, 2}, {3, 4}}) {
+		t.Fatal("unexpected value:", vectors, err)
+	}
+	var short [2]int
+	if err := prop.Root.SearchChild("array").AssignTo(reflect.ValueOf(&short).Elem()); err == nil {
+		t.Fatal("array of invalid length was accepted")
+	}
+
+	node, _ = NewNodeWithValue("ip", net.IPv4(127, 0, 0, 1))
+	var s string
+	var ip uint32
+	if err := node.AssignTo(reflect.ValueOf(&s).Elem()); err != nil || s != "127.0.0.1" {
+		t.Fatal("unexpected value:", s, err)
+	}
+	if err := node.AssignTo(reflect.ValueOf(&ip).Elem()); err != nil || ip != 0x7F000001 {
+		t.Fatal("unexpected value:", ip, err)
+	}
+	var bytes4 [4]byte
+	if err := node.AssignTo(reflect.ValueOf(&bytes4).Elem()); err != nil || bytes4 != [4]byte{127, 0, 0, 1} {
+		t.Fatal("unexpected value:", bytes4, err)
+	}
+	var ints4 [4]int
+	if err := node.AssignTo(reflect.ValueOf(&ints4).Elem()); err == nil {
+		t.Fatal("array of invalid type was accepted")
+	}
+
+	node, _ = NewNodeWithValue("time", TimeValue(1000))
+	var tv time.Time
+	if err := node.AssignTo(reflect.ValueOf(&tv).Elem()); err != nil || tv.Unix() != 1000 {
+		t.Fatal("unexpected value:", tv, err)
+	}
+
+	if err := node.AssignTo(reflect.ValueOf(i)); err == nil {
+		t.Fatal("value that cannot be set was accepted")
+	}
+}
+
+func TestPreserveWhitespace(t *testing.T) {
+	const doc = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n\n" +
+		"<root>\n" +
+		"  <a __type=\"s32\">1</a>\n" +
+		"\t<b attr=\"v\">\n" +
+		"\t\t<c __type=\"str\">  text  </c> <d></d>\n" +
+		"\t\t<e>   </e>\n" +
+		"\t</b>\n" +
+		"</root>\n"
+
+	prop := &Property{}
+	prop.Settings.PreserveWhitespace = true
+	if err := prop.Read(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.SearchChild("b").SearchChild("c").StringValue(); v != "  text  " {
+		t.Fatalf("unexpected value: %q", v)
+	}
+	for _, format := range []PropertyFormat{FormatXML, FormatPrettyXML} {
+		prop.Settings.Format = format
+		wr := &strings.Builder{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if wr.String() != doc {
+			t.Fatalf("round-trip failed:\n%s", wr.String())
+		}
+	}
+
+	// the whitespace is only written if the setting is enabled
+	// during both operations
+	prop.Settings.PreserveWhitespace = false
+	wr := &strings.Builder{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if wr.String() == doc {
+		t.Fatal("whitespace was preserved")
+	}
+}
+
+func TestAll(t *testing.T) {
+	prop, _ := NewProperty("root")
+	a, _ := prop.Root.NewNode("a")
+	a.NewNode("b")
+	a.NewNode("c")
+	prop.Root.NewNode("d")
+
+	var names []string
+	for node := range prop.Root.All() {
+		names = append(names, node.Name().String())
+	}
+	if !reflect.DeepEqual(names, []string{"root", "a", "b", "c", "d"}) {
+		t.Fatal("unexpected order:", names)
+	}
+
+	names = nil
+	for node := range prop.Root.Descendants() {
+		if node.Name().String() == "c" {
+			break
+		}
+		names = append(names, node.Name().String())
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatal("unexpected order:", names)
+	}
+}
+
+func TestEquals(t *testing.T) {
+	build := func(attribs ...string) *Node {
+		root, _ := NewNode("root")
+		for i := 0; i < len(attribs); i += 2 {
+			root.SetAttribute(attribs[i], attribs[i+1])
+		}
+		root.NewNodeWithValue("f", float32(math.NaN()))
+		root.NewNodeWithValue("array", []int32{1, 2, 3})
+		root.NewNodeWithValue("bin", BinValue(nil))
+		return root
+	}
+
+	a, b := build("x", "1", "y", "2"), build("y", "2", "x", "1")
+	if !a.Equals(b) {
+		t.Fatal("equal trees are not equal")
+	}
+	if a.EqualApprox(b, 0) {
+		t.Fatal("EqualApprox ignored the order of attributes")
+	}
+	b.SearchChild("bin").SetValue(BinValue{})
+	if !a.Equals(b) {
+		t.Fatal("nil and empty bin values are not equal")
+	}
+
+	b.SetAttribute("x", "2")
+	if a.Equals(b) {
+		t.Fatal("different attributes are equal")
+	}
+	b = build("x", "1", "y", "2", "z", "3")
+	if a.Equals(b) {
+		t.Fatal("different numbers of attributes are equal")
+	}
+	b = build("x", "1", "y", "2")
+	b.SearchChild("array").SetValue([]int32{1, 2, 4})
+	if a.Equals(b) {
+		t.Fatal("different arrays are equal")
+	}
+}
+
+func TestSetValueNil(t *testing.T) {
+	node, _ := NewNode("node")
+	if err := node.SetValue(nil); err == nil || !strings.Contains(err.Error(), "cannot set nil value") {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := node.SetValue((*int32)(nil)); err == nil || !strings.Contains(err.Error(), "cannot set nil value") {
+		t.Fatal("unexpected error:", err)
+	}
+
+	i := int32(5)
+	if err := node.SetValue(&i); err != nil {
+		t.Fatal(err)
+	}
+	if node.Type() != S32Node || node.Value() != int32(5) {
+		t.Fatal("pointer was not dereferenced:", node.Value())
+	}
+
+	var s []uint16
+	if err := node.SetValue(s); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := node.Value().([]uint16); !ok || v == nil || len(v) != 0 || !node.IsArray() {
+		t.Fatal("nil slice was not stored as an empty array:", node.Value())
+	}
+
+	var ip net.IP
+	if err := node.SetValue(ip); err == nil {
+		t.Fatal("nil ip was accepted")
+	}
+	if err := node.SetValue(BinValue(nil)); err != nil || node.Type() != BinNode {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestReadXMLSplitCharData(t *testing.T) {
+	const doc = `<root>` +
+		`<bin __type="bin">&#10;de&#x61;d<!-- comment -->be<![CDATA[ef]]>&#9;</bin>` +
+		`<array __type="s32" __count="3">1 <!-- comment -->2 3</array>` +
+		`<str __type="str"> a&#32;<![CDATA[<b>]]> </str>` +
+		`</root>`
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.SearchChild("bin").BinaryValue(); !bytes.Equal(v, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Fatal("unexpected value:", v)
+	}
+	if v, err := ArrayAs[int32](prop.Root.SearchChild("array")); err != nil || !reflect.DeepEqual(v, []int32{1, 2, 3}) {
+		t.Fatal("unexpected value:", v, err)
+	}
+	if v := prop.Root.SearchChild("str").StringValue(); v != " a <b> " {
+		t.Fatalf("unexpected value: %q", v)
+	}
+}
+
+func TestInsertChildAt(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	prop.Root.SetValue(int32(1))
+
+	for _, v := range []struct {
+		i    int
+		name string
+	}{{0, "b"}, {0, "a"}, {2, "d"}, {2, "c"}} {
+		c, _ := NewNode(v.name)
+		if err := prop.Root.InsertChildAt(v.i, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _ := NewNode("e")
+	for _, i := range []int{-1, 5} {
+		if err := prop.Root.InsertChildAt(i, c); err == nil {
+			t.Fatal("out of range index was accepted:", i)
+		}
+	}
+	if err := prop.Root.InsertChildAt(0, prop.Root.Children()[1]); err == nil {
+		t.Fatal("child that already has a parent was accepted")
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); s != `<?xml version="1.0"?><root><a></a><b></b><c></c><d></d></root>` {
+		t.Fatal("unexpected output:", s)
+	}
+	if c.Parent() != nil || prop.Root.Children()[2].Parent() != prop.Root {
+		t.Fatal("unexpected parent")
+	}
+}
+
+func TestSubProperty(t *testing.T) {
+	inner, _ := NewProperty("inner")
+	inner.Settings.Encoding = EncodingSJIS
+	inner.Root.NewNodeWithValue("str", "テスト")
+	inner.Root.NewNodeWithValue("array", []uint8{1, 2, 3})
+
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop, _ := NewProperty("outer")
+		prop.Settings.Format = format
+		node, _ := prop.Root.NewNode("embedded")
+		if err := node.SetSubProperty(inner); err != nil {
+			t.Fatal(err)
+		}
+
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if err := prop.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+
+		embedded, err := prop.Root.SearchChild("embedded").SubProperty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if embedded.Encoding() != EncodingSJIS || !embedded.Root.Equals(inner.Root) {
+			t.Fatal("embedded property was not preserved")
+		}
+	}
+
+	node, _ := NewNodeWithValue("node", BinValue{1, 2, 3})
+	if _, err := node.SubProperty(); err == nil {
+		t.Fatal("invalid property was accepted")
+	}
+	node.SetValue(int32(1))
+	if _, err := node.SubProperty(); err == nil {
+		t.Fatal("non-bin node was accepted")
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	a, _ := prop.Root.NewNode("a")
+	b, _ := a.NewNode("b")
+	c, _ := b.NewNode("c")
+	other, _ := NewNode("other")
+
+	if err := a.MoveTo(c); err == nil {
+		t.Fatal("node was moved under one of its descendants")
+	}
+	if err := a.MoveTo(a); err == nil {
+		t.Fatal("node was moved under itself")
+	}
+	if err := a.MoveTo(nil); err == nil || a.Parent() != prop.Root {
+		t.Fatal("node was moved to nil parent")
+	}
+	if err := b.MoveTo(prop.Root); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.MoveTo(c); err != nil {
+		t.Fatal(err)
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); s != `<?xml version="1.0"?><root><a></a><b><c><other></other></c></b></root>` {
+		t.Fatal("unexpected output:", s)
+	}
+	if b.Parent() != prop.Root || other.Parent() != c || len(a.Children()) != 0 {
+		t.Fatal("unexpected parent")
+	}
+}
+
+func TestReadXMLVectorArrayCount(t *testing.T) {
+	for _, v := range []struct {
+		doc, err string
+	}{
+		{`<v __type="3f" __count="2">1 2 3 4 5</v>`, "expected 6 fields, got 5"},
+		{`<v __type="3f" __count="1">1 2 3 4 5 6</v>`, "expected 3 fields, got 6"},
+		{`<v __type="3f" __count="2"></v>`, "expected 6 fields, got 0"},
+		{`<v __type="3f" __count="-1">1 2 3</v>`, "invalid __count attribute"},
+		// the expected number of fields overflows to 2
+		{`<v __type="3f" __count="6148914691236517206">1 2</v>`, "expected 6148914691236517206 elements of 3 fields, got 2"},
+		{`<v __type="3f" __count="6148914691236517206"></v>`, "got 0"},
+	} {
+		prop := &Property{}
+		err := prop.Read(strings.NewReader(v.doc))
+		if err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Fatal("unexpected error:", v.doc, err)
+		}
+	}
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<v __type="3f" __count="2">1 2 3 4 5 6</v>`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ArrayAs[[3]float32](prop.Root); err != nil || !reflect.DeepEqual(v, [][3]float32{{1, 2, 3}, {4, 5, 6}}) {
+		t.Fatal("unexpected value:", v, err)
+	}
+}
+
+func TestSortChildren(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	for i, name := range []string{"c", "a", "b", "a"} {
+		prop.Root.NewNodeWithValue(name, int32(i))
+	}
+
+	prop.Root.SortChildrenByName()
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); s != `<?xml version="1.0"?><root><a __type="s32">1</a><a __type="s32">3</a>`+
+		`<b __type="s32">2</b><c __type="s32">0</c></root>` {
+		t.Fatal("unexpected output:", s)
+	}
+
+	prop.Root.SortChildren(func(a, b *Node) bool {
+		return a.Value().(int32) > b.Value().(int32)
+	})
+	var values []int32
+	for _, c := range prop.Root.Children() {
+		values = append(values, c.Value().(int32))
+	}
+	if !reflect.DeepEqual(values, []int32{3, 2, 1, 0}) {
+		t.Fatal("unexpected order:", values)
+	}
+}
+
+func TestSiblings(t *testing.T) {
+	root, _ := NewNode("root")
+	a, _ := root.NewNode("a")
+	b, _ := root.NewNode("b")
+	c, _ := root.NewNode("c")
+
+	if root.Index() != -1 || root.NextSibling() != nil || root.PrevSibling() != nil {
+		t.Fatal("root has siblings")
+	}
+	if a.Index() != 0 || b.Index() != 1 || c.Index() != 2 {
+		t.Fatal("unexpected index")
+	}
+	if a.PrevSibling() != nil || a.NextSibling() != b || b.PrevSibling() != a ||
+		b.NextSibling() != c || c.PrevSibling() != b || c.NextSibling() != nil {
+		t.Fatal("unexpected sibling")
+	}
+
+	b.Cut()
+	if b.Index() != -1 || a.NextSibling() != c || c.Index() != 1 {
+		t.Fatal("unexpected sibling after cut")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatPrettyXML
+	prop.Settings.Encoding = EncodingUTF8
+	prop.Root.NewNodeWithValue("str", "value")
+	prop.Root.NewNodeWithValue("array", []int16{-1, 0, 1})
+
+	var (
+		m encoding.BinaryMarshaler   = prop
+		u encoding.BinaryUnmarshaler = &Property{}
+	)
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prop.Settings.Format != FormatPrettyXML {
+		t.Fatal("format was modified")
+	}
+	if len(b) == 0 || b[0] != binaryMagic>>8 {
+		t.Fatal("output is not binary")
+	}
+
+	if err := u.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if result := u.(*Property); !result.Root.Equals(prop.Root) || result.Encoding() != EncodingUTF8 {
+		t.Fatal("round-trip failed")
+	}
+	for _, doc := range []string{"", "<root/>", `{"name":"root"}`} {
+		if err := u.UnmarshalBinary([]byte(doc)); err == nil {
+			t.Fatalf("%q was unmarshaled", doc)
+		}
+	}
+
+	// gob uses the interfaces as well
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(prop); err != nil {
+		t.Fatal(err)
+	}
+	result := &Property{}
+	if err := gob.NewDecoder(buf).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Root.Equals(prop.Root) {
+		t.Fatal("gob round-trip failed")
+	}
+}
+
+func TestRootDepth(t *testing.T) {
+	root, _ := NewNode("root")
+	a, _ := root.NewNode("a")
+	b, _ := a.NewNode("b")
+
+	if root.Root() != root || a.Root() != root || b.Root() != root {
+		t.Fatal("unexpected root")
+	}
+	if root.Depth() != 0 || a.Depth() != 1 || b.Depth() != 2 {
+		t.Fatal("unexpected depth")
+	}
+
+	a.Cut()
+	if b.Root() != a || b.Depth() != 1 {
+		t.Fatal("unexpected root or depth after cut")
+	}
+}
+
+func TestChunkArray(t *testing.T) {
+	large := make([]uint8, maxValueSize+1)
+	for i := range large {
+		large[i] = uint8(i)
+	}
+	prop, _ := NewProperty("root")
+	node, _ := prop.Root.NewNodeWithValue("large", large)
+	if err := prop.Write(io.Discard); err == nil {
+		t.Fatal("oversized array was written")
+	}
+
+	if err := node.ChunkArray("chunk", maxValueSize); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Children()) != 2 || node.Type() != VoidNode || node.Children()[1].ArrayLength() != 1 {
+		t.Fatal("unexpected chunks")
+	}
+	large[0] = 0xFF
+	if node.Children()[0].ArrayElements()[0] != uint8(0) {
+		t.Fatal("chunk shares its elements with the original value")
+	}
+	large[0] = 0
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	node = prop.Root.SearchChild("large")
+	if err := node.JoinChunkedArray("chunk"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := node.Value().([]uint8); !ok || !bytes.Equal(v, large) || len(node.Children()) != 0 {
+		t.Fatal("round-trip failed")
+	}
+
+	// chunks that were read from an XML document
+	node, _ = NewNodeWithValue("array", []int32{1, 2, 3, 4, 5})
+	node.ChunkArray("c", 2)
+	node.Children()[0].SetValue([]int32{1, 2})
+	node.Children()[1].value = []any{int32(3), int32(4)}
+	if err := node.JoinChunkedArray("c"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ArrayAs[int32](node); err != nil || !reflect.DeepEqual(v, []int32{1, 2, 3, 4, 5}) {
+		t.Fatal("unexpected value:", v, err)
+	}
+
+	node, _ = NewNodeWithValue("array", []int32{})
+	if err := node.ChunkArray("c", 2); err != nil || len(node.Children()) != 1 {
+		t.Fatal("unexpected chunks:", err)
+	}
+	node.NewNodeWithValue("c", []int8{1})
+	if err := node.JoinChunkedArray("c"); err == nil {
+		t.Fatal("chunks of different types were joined")
+	}
+	if err := node.ChunkArray("c", 2); err == nil {
+		t.Fatal("void node was chunked")
+	}
+}
+
+func TestNodeNamePackedSize(t *testing.T) {
+	for length := 1; length <= nodeNameSize; length++ {
+		name, err := NewNodeName(strings.Repeat("a", length))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := name.PackedSize(); size != (length*6+7)/8 || size != name.BinarySize(false) {
+			t.Fatal("unexpected packed size:", length, size)
+		}
+		if size := name.BinarySize(true); size != length {
+			t.Fatal("unexpected long size:", length, size)
+		}
+
+		wr := &bytes.Buffer{}
+		if err := name.writeBinary(wr, false); err != nil {
+			t.Fatal(err)
+		}
+		if wr.Len() != name.PackedSize()+1 {
+			t.Fatal("packed size does not match output:", length, wr.Len())
+		}
+	}
+}
+
+func TestFloatValue(t *testing.T) {
+	for _, v := range []struct {
+		value    any
+		expected float64
+	}{
+		{float32(1.5), 1.5},
+		{float64(-2.25), -2.25},
+		{int32(3), 0},
+		{[2]float32{1, 2}, 0},
+		{[]float64{1, 2}, 0},
+	} {
+		node, err := NewNodeWithValue("node", v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := node.FloatValue(); f != v.expected {
+			t.Fatal("unexpected value:", v.value, f)
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("value", int32(1))
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2; i++ {
+			if err := WriteConn(client, prop, time.Second); err != nil {
+				errs <- err
+				return
+			}
+			prop.Settings.Format = FormatXML
+		}
+		errs <- nil
+	}()
+
+	for i := 0; i < 2; i++ {
+		result, err := ReadConn(server, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Root.Equals(prop.Root) {
+			t.Fatal("unexpected property")
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is written, so the read times out
+	if _, err := ReadConn(server, 10*time.Millisecond); err == nil {
+		t.Fatal("read did not time out")
+	} else if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// truncated frame
+	go client.Write([]byte{0, 0, 0, 8, 0xA0, 0x42})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	if _, err := ReadConn(server, time.Second); err != io.ErrUnexpectedEOF {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// the size is checked before anything else is read
+	if _, err := readFrame(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF})); err == nil ||
+		!strings.Contains(err.Error(), "too large") {
+		t.Fatal("unexpected error:", err)
+	}
+	prop.Root.NewNodeWithValue("large", BinValue(make([]byte, maxFrameSize)))
+	prop.Settings.Format = FormatBinary
+	prop.Settings.MaxValueSize = maxFrameSize
+	if err := writeFrame(io.Discard, prop); err == nil {
+		t.Fatal("large frame was written")
+	}
+}
+
+func TestBoolValue(t *testing.T) {
+	node, _ := NewNode("node")
+	if node.BoolValue() {
+		t.Fatal("void node has a true value")
+	}
+	if err := node.SetBool(true); err != nil {
+		t.Fatal(err)
+	}
+	if node.Type() != BoolNode || !node.BoolValue() {
+		t.Fatal("unexpected value:", node.Value())
+	}
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<b __type="bool">1</b>`)); err != nil {
+		t.Fatal(err)
+	}
+	if !prop.Root.BoolValue() {
+		t.Fatal("unexpected value:", prop.Root.Value())
+	}
+
+	node.SetValue(uint8(1))
+	if node.BoolValue() {
+		t.Fatal("u8 node has a true value")
+	}
+}
+
+func TestCanonicalizeFloats(t *testing.T) {
+	negZero32, negZero64 := float32(math.Copysign(0, -1)), math.Copysign(0, -1)
+	nan32, nan64 := math.Float32frombits(0xFFC00001), math.Float64frombits(0x7FF0000000000123)
+
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("f", negZero32)
+	prop.Root.NewNodeWithValue("d", nan64)
+	prop.Root.NewNodeWithValue("vec", [2]float32{nan32, negZero32})
+	prop.Root.NewNodeWithValue("array", []float64{negZero64, nan64, 1.5})
+	prop.Root.NewNodeWithValue("int", int32(-1))
+
+	check := func(result *Property, canonical bool) {
+		bits32 := func(f any) uint32 {
+			return math.Float32bits(f.(float32))
+		}
+		bits64 := func(f any) uint64 {
+			return math.Float64bits(f.(float64))
+		}
+
+		f := bits32(result.Root.SearchChild("f").Value())
+		d := bits64(result.Root.SearchChild("d").Value())
+		vec := result.Root.SearchChild("vec").Value().([2]any)
+		array := result.Root.SearchChild("array").ArrayElements()
+		if canonical {
+			if f != 0 || d != 0x7FF8000000000000 || bits32(vec[0]) != 0x7FC00000 || bits32(vec[1]) != 0 ||
+				bits64(array[0]) != 0 || bits64(array[1]) != 0x7FF8000000000000 || array[2] != 1.5 {
+				t.Fatal("floats were not canonicalized")
+			}
+		} else if f != 0x80000000 || d != 0x7FF0000000000123 || bits32(vec[0]) != 0xFFC00001 {
+			t.Fatal("floats were modified")
+		}
+		if result.Root.SearchChild("int").Value() != int32(-1) {
+			t.Fatal("int was modified")
+		}
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	for _, canonical := range []bool{false, true} {
+		result := &Property{}
+		result.Settings.CanonicalizeFloats = canonical
+		if err := result.Read(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		check(result, canonical)
+	}
+
+	result := &Property{}
+	result.Settings.CanonicalizeFloats = true
+	doc := `<root><f __type="float">-0</f><d __type="double">NaN</d><vec __type="2f">NaN -0</vec>` +
+		`<array __type="double" __count="3">-0 NaN 1.5</array><int __type="s32">-1</int></root>`
+	if err := result.Read(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	check(result, true)
+}
+
+func TestIPValue(t *testing.T) {
+	node, _ := NewNode("node")
+	if node.IPValue() != nil {
+		t.Fatal("void node has an ip value")
+	}
+	if err := node.SetIP(net.IPv4(192, 168, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if node.Type() != IPv4Node || !node.IPValue().Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Fatal("unexpected value:", node.Value())
+	}
+	for _, ip := range []net.IP{nil, net.ParseIP("::1")} {
+		if err := node.SetIP(ip); err == nil {
+			t.Fatal("invalid ip was accepted:", ip)
+		}
+	}
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<ip __type="ip4">10.0.0.1</ip>`)); err != nil {
+		t.Fatal(err)
+	}
+	if !prop.Root.IPValue().Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatal("unexpected value:", prop.Root.Value())
+	}
+
+	node.SetValue(uint32(1))
+	if node.IPValue() != nil {
+		t.Fatal("u32 node has an ip value")
+	}
+}
+
+func TestToMap(t *testing.T) {
+	m := testcaseNode.ToMap()
+	if len(m) == 0 {
+		t.Fatal("map is empty")
+	}
+	for _, c := range testcaseNode.Children() {
+		if _, ok := m[c.Name().String()]; !ok {
+			t.Fatal("missing key:", c.Name())
+		}
+	}
+	if s8, ok := m["entry_s8"].([]any); !ok || len(s8) != 2 || s8[0] != int8(123) {
+		t.Fatal("unexpected value:", m["entry_s8"])
+	}
+
+	root, _ := NewNode("root")
+	root.SetAttribute("attr", "value")
+	root.NewNodeWithValue("array", []int32{1, 2})
+	sub, _ := root.NewNode("sub")
+	sub.SetAttribute("a", "b")
+	sub.NewNodeWithValue("x", "y")
+	expected := map[string]any{
+		"@attr": "value",
+		"array": []int32{1, 2},
+		"sub": map[string]any{
+			"@a": "b",
+			"x":  "y",
+		},
+	}
+	if m := root.ToMap(); !reflect.DeepEqual(m, expected) {
+		t.Fatal("unexpected map:", m)
+	}
+
+	// the map is converted back, except for the attributes
+	delete(expected, "@attr")
+	delete(expected["sub"].(map[string]any), "@a")
+	result, _ := NewNode("root")
+	if err := result.SetFromMap(expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.ToMap(), expected) {
+		t.Fatal("round-trip failed")
+	}
+}
+
+func TestReadSettings(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Encoding = EncodingSJIS
+	prop.Settings.UseLongNodeNames = true
+	prop.Settings.DedupeStrings = true
+	prop.Root.NewNodeWithValue("str", "value")
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+
+	result := &Property{}
+	result.Settings.Format = FormatPrettyXML
+	result.Settings.Encoding = EncodingUTF8
+	result.Settings.MaxDepth = 10
+	if err := result.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	detected := result.Settings
+	if detected.Format != FormatBinary || detected.Encoding != EncodingSJIS || !detected.UseLongNodeNames ||
+		!detected.DedupeStrings || detected.MaxDepth != 10 {
+		t.Fatal("unexpected settings:", detected)
+	}
+	if !reflect.DeepEqual(result.DetectedSettings(), detected) {
+		t.Fatal("unexpected detected settings")
+	}
+
+	result = &Property{}
+	result.Settings.Format = FormatPrettyXML
+	result.Settings.Encoding = EncodingUTF8
+	result.Settings.PreserveSettings = true
+	if err := result.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if s := result.Settings; s.Format != FormatPrettyXML || s.Encoding != EncodingUTF8 || s.UseLongNodeNames || s.DedupeStrings {
+		t.Fatal("settings were overwritten:", s)
+	}
+	if d := result.DetectedSettings(); d.Format != FormatBinary || d.Encoding != EncodingSJIS || !d.UseLongNodeNames {
+		t.Fatal("unexpected detected settings:", d)
+	}
+
+	// the document is converted using the preserved settings
+	wr.Reset()
+	if err := result.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); !strings.HasPrefix(s, `<?xml version="1.0" encoding="UTF-8"?>`+"\n<root>") {
+		t.Fatal("unexpected output:", s)
+	}
+}
+
+func TestIntFloatArray(t *testing.T) {
+	for _, v := range []struct {
+		value  any
+		ints   []int64
+		floats []float64
+	}{
+		{[]int8{-1, 2}, []int64{-1, 2}, nil},
+		{[]uint64{1, 2}, []int64{1, 2}, nil},
+		{[3]int32{1, 2, 3}, []int64{1, 2, 3}, nil},
+		{[][2]uint16{{1, 2}, {3, 4}}, []int64{1, 2, 3, 4}, nil},
+		{[]float32{1.5, 2}, nil, []float64{1.5, 2}},
+		{[][2]float64{{1, 2}, {3, 4}}, nil, []float64{1, 2, 3, 4}},
+		{int32(1), nil, nil},
+		{float32(1), nil, nil},
+		{net.IPv4(1, 2, 3, 4), nil, nil},
+		{[]BoolValue{true}, nil, nil},
+		{"str", nil, nil},
+	} {
+		node, err := NewNodeWithValue("node", v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := node.IntArray(); !reflect.DeepEqual(s, v.ints) {
+			t.Fatal("unexpected ints:", v.value, s)
+		}
+		if s := node.FloatArray(); !reflect.DeepEqual(s, v.floats) {
+			t.Fatal("unexpected floats:", v.value, s)
+		}
+	}
+
+	// values that were read from documents
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<root><v __type="2s32" __count="2">1 2 3 4</v><f __type="3f">1 2 3</f></root>`)); err != nil {
+		t.Fatal(err)
+	}
+	if s := prop.Root.SearchChild("v").IntArray(); !reflect.DeepEqual(s, []int64{1, 2, 3, 4}) {
+		t.Fatal("unexpected ints:", s)
+	}
+	if s := prop.Root.SearchChild("f").FloatArray(); !reflect.DeepEqual(s, []float64{1, 2, 3}) {
+		t.Fatal("unexpected floats:", s)
+	}
+
+	// empty arrays are not nil
+	node, _ := NewNodeWithValue("node", []int32{})
+	if s := node.IntArray(); s == nil || len(s) != 0 {
+		t.Fatal("unexpected ints:", s)
+	}
+}
+
+func TestCaseInsensitiveMeta(t *testing.T) {
+	prop := &Property{}
+	prop.Settings.CaseInsensitiveMeta = true
+	if err := prop.Read(strings.NewReader(`<x __TYPE="s32">5</x>`)); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Root.Type() != S32Node || prop.Root.Value() != int32(5) {
+		t.Fatal("unexpected value:", prop.Root.Type(), prop.Root.Value())
+	}
+	if err := prop.Read(strings.NewReader(`<x __Type="u8" __Count="2">1 2</x>`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ArrayAs[uint8](prop.Root); err != nil || !reflect.DeepEqual(v, []uint8{1, 2}) {
+		t.Fatal("unexpected value:", v, err)
+	}
+	if err := prop.Read(strings.NewReader(`<x __type="bin" __SIZE="1">01</x>`)); err != nil || len(prop.Root.Attributes()) != 0 {
+		t.Fatal("unexpected result:", err, prop.Root.Attributes())
+	}
+
+	prop.Settings.CaseInsensitiveMeta = false
+	if err := prop.Read(strings.NewReader(`<x __TYPE="s32">5</x>`)); err == nil && prop.Root.Type() == S32Node {
+		t.Fatal("__TYPE was recognized")
+	}
+}
+
+func TestValue(t *testing.T) {
+	node, _ := NewNodeWithValue("node", float32(1.5))
+	if v, ok := Value[float32](node); !ok || v != 1.5 {
+		t.Fatal("unexpected value:", v, ok)
+	}
+	if v, ok := Value[float64](node); ok || v != 0 {
+		t.Fatal("unexpected value:", v, ok)
+	}
+
+	node.SetValue(BinValue{1, 2})
+	if v, ok := Value[BinValue](node); !ok || !bytes.Equal(v, []byte{1, 2}) {
+		t.Fatal("unexpected value:", v, ok)
+	}
+	node.SetValue(net.IPv4(1, 2, 3, 4))
+	if v, ok := Value[net.IP](node); !ok || !v.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Fatal("unexpected value:", v, ok)
+	}
+
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<v __type="3f">1 2 3</v>`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := Value[[3]float32](prop.Root); !ok || v != [3]float32{1, 2, 3} {
+		t.Fatal("unexpected value:", v, ok)
+	}
+	if v, ok := Value[[3]int32](prop.Root); ok || v != [3]int32{} {
+		t.Fatal("unexpected value:", v, ok)
+	}
+
+	node, _ = NewNode("void")
+	if _, ok := Value[any](node); ok {
+		t.Fatal("void node has a value")
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	dir := t.TempDir()
+	binPath, xmlPath := dir+"/test.bin", dir+"/test.xml"
+
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatXML
+	prop.Root.NewNodeWithValue("value", int32(1))
+	if err := prop.WriteBoth(binPath, xmlPath); err != nil {
+		t.Fatal(err)
+	}
+	if prop.Settings.Format != FormatXML {
+		t.Fatal("format was not restored")
+	}
+	for path, format := range map[string]PropertyFormat{binPath: FormatBinary, xmlPath: FormatXML} {
+		result := &Property{}
+		if err := result.ReadFile(path); err != nil {
+			t.Fatal(err)
+		}
+		if result.Settings.Format != format || !result.Root.Equals(prop.Root) {
+			t.Fatal("unexpected file:", path)
+		}
+	}
+
+	// files are removed if one of them cannot be written
+	os.Remove(binPath)
+	os.Remove(xmlPath)
+	invalidPath := dir + "/missing/test.bin"
+	if err := prop.WriteFormats(FormatFile{xmlPath, FormatXML}, FormatFile{invalidPath, FormatBinary}); err == nil {
+		t.Fatal("invalid path was accepted")
+	}
+	prop.Root.NewNodeWithValue("large", make([]uint8, maxValueSize+1))
+	if err := prop.WriteFormats(FormatFile{binPath, FormatBinary}); err == nil {
+		t.Fatal("oversized property was written")
+	}
+	for _, path := range []string{binPath, xmlPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("file was not removed:", path)
+		}
+	}
+	if prop.Settings.Format != FormatXML {
+		t.Fatal("format was not restored")
+	}
+}
+
+func TestAppendSetElement(t *testing.T) {
+	node, _ := NewNode("node")
+	for _, v := range []int32{1, 2, 3} {
+		if err := node.AppendElement(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node.SetElement(1, int32(5)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := node.Value().([]int32); !ok || !reflect.DeepEqual(v, []int32{1, 5, 3}) || node.Type() != S32Node {
+		t.Fatal("unexpected value:", node.Value())
+	}
+	for _, i := range []int{-1, 3} {
+		if err := node.SetElement(i, int32(0)); err == nil {
+			t.Fatal("out of range index was accepted:", i)
+		}
+	}
+	for _, v := range []any{int64(1), "str", BinValue{}, struct{}{}} {
+		if err := node.AppendElement(v); err == nil {
+			t.Fatalf("invalid element was accepted: %T", v)
+		}
+		if err := node.SetElement(0, v); err == nil {
+			t.Fatalf("invalid element was accepted: %T", v)
+		}
+	}
+
+	// scalars are promoted to arrays
+	node, _ = NewNodeWithValue("node", uint16(1))
+	if err := node.AppendElement(uint16(2)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := node.Value().([]uint16); !ok || !reflect.DeepEqual(v, []uint16{1, 2}) || !node.IsArray() {
+		t.Fatal("unexpected value:", node.Value())
+	}
+
+	// values that were read from a document
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<root><a __type="2u8" __count="1">1 2</a><v __type="2u8">3 4</v></root>`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "v"} {
+		node := prop.Root.SearchChild(name)
+		if err := node.AppendElement([2]uint8{5, 6}); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.SetElement(0, [2]uint8{7, 8}); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := ArrayAs[[2]uint8](node); err != nil || !reflect.DeepEqual(v, [][2]uint8{{7, 8}, {5, 6}}) {
+			t.Fatal("unexpected value:", v, err)
+		}
+	}
+	prop.Settings.Format = FormatXML
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); s != `<?xml version="1.0" encoding="UTF-8"?><root><a __type="2u8" __count="2">7 8 5 6</a>`+
+		`<v __type="2u8" __count="2">7 8 5 6</v></root>` {
+		t.Fatal("unexpected output:", s)
+	}
+
+	prop.Settings.Format = FormatBinary
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ArrayAs[[2]uint8](prop.Root.SearchChild("v")); err != nil || !reflect.DeepEqual(v, [][2]uint8{{7, 8}, {5, 6}}) {
+		t.Fatal("unexpected value:", v, err)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatJSON
+	prop.Root.NewNodeWithValue("s32", int32(-5))
+	prop.Root.NewNodeWithValue("u32", []uint32{1, 2})
+	prop.Root.NewNodeWithValue("str", "value")
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"root","children":[` +
+		`{"name":"s32","__type":"s32","value":-5},` +
+		`{"name":"u32","__type":"u32","__count":2,"value":[1,2]},` +
+		`{"name":"str","__type":"str","value":"value"}]}` + "\n"
+	if s := wr.String(); s != expected {
+		t.Fatal("unexpected output:", s)
+	}
+
+	prop.Settings.Format = FormatPrettyJSON
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if s := wr.String(); !strings.HasPrefix(s, "{\n    \"name\": \"root\",\n") {
+		t.Fatal("unexpected output:", s)
+	}
+
+	var v any
+	if err := json.Unmarshal(wr.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testcaseNode.MarshalJSON(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nil values are written without a value, and read back as
+	// zero values, where count distinguishes arrays from scalars
+	prop, _ = NewProperty("root")
+	prop.Settings.Format = FormatJSON
+	prop.Root.NewNodeWithValue("scalar", uint16(1))
+	prop.Root.NewNodeWithValue("array", []uint16{1})
+	for _, c := range prop.Root.Children() {
+		c.value = nil
+	}
+	if err := prop.Write(io.Discard); err == nil {
+		t.Fatal("nil value was written")
+	}
+	prop.Settings.AllowNilValues = true
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"name":"root","children":[` +
+		`{"name":"scalar","__type":"u16"},` +
+		`{"name":"array","__type":"u16","__count":0}]}` + "\n"
+	if s := wr.String(); s != expected {
+		t.Fatal("unexpected output:", s)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if v := prop.Root.SearchChild("scalar"); v.Value() != uint16(0) || v.IsArray() {
+		t.Fatal("unexpected scalar:", v.Value())
+	}
+	if v := prop.Root.SearchChild("array"); !v.IsArray() || v.ArrayLength() != 0 || v.Type() != U16Node {
+		t.Fatal("unexpected array:", v.Value())
+	}
+}
+
+func TestPropertyFormatString(t *testing.T) {
+	for _, f := range []PropertyFormat{FormatBinary, FormatXML, FormatPrettyXML, FormatJSON, FormatPrettyJSON} {
+		parsed, err := ParsePropertyFormat(f.String())
+		if err != nil || parsed != f {
+			t.Fatal("round-trip failed:", f, parsed, err)
+		}
+	}
+	if s := FormatPrettyXML.String(); s != "prettyxml" {
+		t.Fatal("unexpected string:", s)
+	}
+	if f, err := ParsePropertyFormat("XML"); err != nil || f != FormatXML {
+		t.Fatal("unexpected format:", f, err)
+	}
+	if _, err := ParsePropertyFormat("yaml"); err == nil {
+		t.Fatal("unknown format was accepted")
+	}
+	if s := PropertyFormat(10).String(); s != "PropertyFormat(10)" {
+		t.Fatal("unexpected string:", s)
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(testcaseBinary)); err != nil {
+		t.Fatal(err)
+	}
+	settings := prop.Settings
+	expected := &bytes.Buffer{}
+	if err := prop.Write(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []PropertyFormat{FormatJSON, FormatPrettyJSON} {
+		prop.Settings.Format = format
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+
+		result := &Property{}
+		if err := result.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if result.Settings.Format != FormatJSON {
+			t.Fatal("unexpected format:", result.Settings.Format)
+		}
+		result.Settings = settings
+		wr.Reset()
+		if err := result.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wr.Bytes(), expected.Bytes()) {
+			t.Fatal("binary -> json -> binary round-trip failed")
+		}
+	}
+
+	// types that share their Go type with another type
+	node, _ := NewNode("bits")
+	node.SetValue([16]BoolValue{true})
+	if err := node.ConvertType(Bitset16Node); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := node.MarshalJSON()
+	result := &Property{}
+	if err := result.Read(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if result.Root.Type() != Bitset16Node {
+		t.Fatal("unexpected type:", result.Root.Type())
+	}
+
+	for _, doc := range []string{
+		`{"name":"x","__type":"invalid","value":1}`,
+		`{"name":"x","__type":"s8","value":300}`,
+		`{"name":"x","__type":"s8"}`,
+		`{"name":"x","__type":"s8","value":1,"children":[{"name":"y"}]}`,
+		`{"name":"__x"}`,
+		`{"name":"x"`,
+		`[{"name":"x"}]`,
+	} {
+		if err := result.Read(strings.NewReader(doc)); err == nil {
+			t.Fatal("invalid document was accepted:", doc)
+		}
+	}
+	// count has to match the value
+	for _, doc := range []string{
+		`{"name":"x","__type":"s8","__count":2,"value":[1]}`,
+		`{"name":"x","__type":"s8","__count":1,"value":1}`,
+	} {
+		if err := result.Read(strings.NewReader(doc)); err == nil {
+			t.Fatal("invalid count was accepted:", doc)
+		}
+	}
+	if err := result.Read(strings.NewReader(`{"name":"x","__type":"s8","__count":0,"value":[]}`)); err != nil ||
+		!result.Root.IsArray() || result.Root.ArrayLength() != 0 {
+		t.Fatal("empty array was not read:", err)
+	}
+
+	if err := result.Read(strings.NewReader(`[]`)); err == nil || !strings.Contains(err.Error(), "not an object") {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// whitespace in front of text formats is skipped
+	for _, doc := range []string{"\n\t {\"name\":\"x\",\"__type\":\"s8\",\"value\":1}", "\r\n<x __type=\"s8\">1</x>"} {
+		if err := result.Read(strings.NewReader(doc)); err != nil {
+			t.Fatal(err)
+		}
+		if v := result.Root.IntValue(); v != 1 {
+			t.Fatal("unexpected value:", v)
+		}
+	}
+	if err := result.Read(bytes.NewReader(append([]byte(" "), testcaseBinary...))); err == nil {
+		t.Fatal("binary document with leading whitespace was accepted")
+	}
+}
+
+func TestReadAs(t *testing.T) {
+	prop := &Property{}
+	if err := prop.ReadAs(bytes.NewReader(testcaseBinary), "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if name := prop.Root.Name().String(); name != "renamed" {
+		t.Fatal("unexpected root name:", name)
+	}
+	if len(prop.Root.Children()) != len(testcaseNode.Children()) {
+		t.Fatal("unexpected children")
+	}
+
+	prop.Root = nil
+	if err := prop.ReadAs(bytes.NewReader(testcaseBinary), "__invalid"); err == nil || prop.Root != nil {
+		t.Fatal("invalid root name was accepted")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	prop, _ := NewProperty("root")
+	a, _ := prop.Root.NewNode("a")
+	b0, _ := a.NewNode("b")
+	b1, _ := a.NewNode("b")
+	c, _ := b1.NewNode("c")
+
+	index := prop.BuildIndex()
+	if index.Len() != 5 {
+		t.Fatal("unexpected number of nodes:", index.Len())
+	}
+	for path, expected := range map[string]*Node{
+		"root":             prop.Root,
+		"/root/a":          a,
+		"root/a/b":         b0,
+		"root/a/b[0]":      b0,
+		"root/a/b[1]":      b1,
+		"root/a[0]/b[1]/c": c,
+		"root/a/b[2]":      nil,
+		"a":                nil,
+	} {
+		if n := index.Get(path); n != expected {
+			t.Fatal("unexpected node:", path, n)
+		}
+	}
+	for _, n := range []*Node{prop.Root, a, b0, c} {
+		if index.Get(n.Path()) != prop.SelectPath(n.Path()) {
+			t.Fatal("index does not match SelectPath:", n.Path())
+		}
+	}
+
+	if index := (&Property{}).BuildIndex(); index.Len() != 0 || index.Get("root") != nil {
+		t.Fatal("index of empty property is not empty")
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("bin", make(BinValue, 100))
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+
+	result := &Property{}
+	result.Settings.MaxValueSize = 50
+	if err := result.Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("value that exceeds the limit was read")
+	}
+	result.Settings.MaxValueSize = 100
+	if err := result.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// raising the limit allows larger arrays
+	prop.Root.NewNodeWithValue("large", make([]uint8, maxValueSize+1))
+	if err := prop.Write(io.Discard); err == nil {
+		t.Fatal("value that exceeds the default limit was written")
+	}
+	prop.Settings.MaxValueSize = maxValueSize * 2
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data = wr.Bytes()
+	result.Settings.MaxValueSize = 0
+	if err := result.Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("value that exceeds the default limit was read")
+	}
+	result.Settings.MaxValueSize = maxValueSize * 2
+	if err := result.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if n := result.Root.SearchChild("large").ArrayLength(); n != maxValueSize+1 {
+		t.Fatal("unexpected length:", n)
+	}
+}
+
+func TestStreamBinaryThreshold(t *testing.T) {
+	prop, _ := NewProperty("root")
+	large := BinValue(bytes.Repeat([]byte{0xAB, 0xCD, 0xEF}, 1000))
+	prop.Root.NewNodeWithValue("small", BinValue{1, 2, 3})
+	prop.Root.NewNodeWithValue("large", large)
+	prop.Root.NewNodeWithValue("after", uint8(7))
+	wr := &bytes.Buffer{}
+	// the document doesn't start at the beginning of the source
+	wr.WriteString("junk")
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := bytes.NewReader(wr.Bytes())
+	rd.Seek(4, io.SeekStart)
+	result := &Property{}
+	result.Settings.StreamBinaryThreshold = 100
+	if err := result.Read(rd); err != nil {
+		t.Fatal(err)
+	}
+
+	node := result.Root.SearchChild("large")
+	if _, ok := node.value.(*streamedBinary); !ok {
+		t.Fatalf("value was not streamed: %T", node.value)
+	}
+	if _, ok := result.Root.SearchChild("small").value.(BinValue); !ok {
+		t.Fatal("small value was streamed")
+	}
+	if v := result.Root.SearchChild("after").UintValue(); v != 7 {
+		t.Fatal("unexpected value after streamed value:", v)
+	}
+
+	b, err := io.ReadAll(node.BinaryReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, large) {
+		t.Fatal("unexpected streamed value")
+	}
+	if n := node.ArrayLength(); n != len(large) {
+		t.Fatal("unexpected length:", n)
+	}
+
+	// writing the tree loads the value
+	wr2 := &bytes.Buffer{}
+	if err := result.Write(wr2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wr2.Bytes(), wr.Bytes()[4:]) {
+		t.Fatal("unexpected output")
+	}
+	if !bytes.Equal(node.BinaryValue(), large) {
+		t.Fatal("unexpected loaded value")
+	}
+
+	// sources that are not seekable are read as usual
+	result.Settings.StreamBinaryThreshold = 1
+	if err := result.Read(bytes.NewBuffer(wr.Bytes()[4:])); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Root.SearchChild("large").value.(BinValue); !ok {
+		t.Fatal("value was streamed from unseekable source")
+	}
+}
+
+func TestEqualExcept(t *testing.T) {
+	build := func(ts TimeValue, nonce string) *Node {
+		root, _ := NewNode("root")
+		info, _ := root.NewNode("info")
+		info.NewNodeWithValue("time", ts)
+		info.NewNodeWithValue("nonce", nonce)
+		root.NewNodeWithValue("score", uint32(100))
+		return root
+	}
+	a, b := build(1000, "abc"), build(2000, "def")
+
+	if a.EqualExcept(b, nil) {
+		t.Fatal("trees with different values are equal")
+	}
+	if a.EqualExcept(b, []string{"root/info/time"}) {
+		t.Fatal("trees with different nonces are equal")
+	}
+	if !a.EqualExcept(b, []string{"root/info/time", "root/info/nonce"}) {
+		t.Fatal("trees are not equal")
+	}
+	if !a.EqualExcept(b, []string{"root/info/*"}) {
+		t.Fatal("trees are not equal with wildcard")
+	}
+
+	// only values are ignored
+	b.SearchChild("info").SearchChild("time").SetValue(uint32(2000))
+	if a.EqualExcept(b, []string{"root/info/*"}) {
+		t.Fatal("trees with different types are equal")
+	}
+	b = build(1000, "abc")
+	b.SearchChild("score").SetValue(uint32(0))
+	if a.EqualExcept(b, []string{"root/info/*"}) {
+		t.Fatal("trees with different scores are equal")
+	}
+}
+
+func TestEncodingEUCKR(t *testing.T) {
+	for _, name := range []string{"EUC-KR", "euckr", "KS_C_5601"} {
+		if e := EncodingByName(name); e != EncodingEUCKR {
+			t.Fatalf("unexpected encoding for %s: %v", name, e)
+		}
+	}
+
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("str", "한국어")
+	prop.Root.SetAttribute("attr", "테스트")
+	prop.Settings.Encoding = EncodingEUCKR
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop.Settings.Format = format
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(wr.Bytes(), []byte("한국어")) {
+			t.Fatal("string was not encoded")
+		}
+
+		result := &Property{}
+		if err := result.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if result.Encoding() != EncodingEUCKR {
+			t.Fatal("unexpected encoding:", result.Encoding())
+		}
+		if s := result.Root.SearchChild("str").StringValue(); s != "한국어" {
+			t.Fatal("unexpected value:", s)
+		}
+		if s := result.Root.AttributeValue("attr"); s != "테스트" {
+			t.Fatal("unexpected attribute:", s)
+		}
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	if v := VoidNode.ZeroValue(); v != nil {
+		t.Fatal("unexpected zero value for void:", v)
+	}
+	if v := S32Node.ZeroValue(); v != int32(0) {
+		t.Fatal("unexpected zero value for s32:", v)
+	}
+	if v := Vec3FloatNode.ZeroValue(); v != [3]float32{} {
+		t.Fatal("unexpected zero value for 3f:", v)
+	}
+	if v := BinNode.ZeroValue().(BinValue); v == nil || len(v) != 0 {
+		t.Fatal("unexpected zero value for bin:", v)
+	}
+
+	prop, _ := NewProperty("root")
+	for _, nt := range idLut {
+		if nt == nil || nt == VoidNode {
+			continue
+		}
+		v := nt.ZeroValue()
+		if reflect.TypeOf(v) != nt.rt {
+			t.Fatalf("unexpected type for %s: %T", nt.Name(), v)
+		}
+		node, err := prop.Root.NewNodeWithValue("n"+strconv.Itoa(nt.id), v)
+		if err != nil {
+			t.Fatal(nt.Name(), err)
+		}
+		if nt == Bitset16Node {
+			if err := node.ConvertType(Bitset16Node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if node.Type() != nt {
+			t.Fatalf("unexpected type for %s: %s", nt.Name(), node.Type().Name())
+		}
+	}
+
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop.Settings.Format = format
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		result := &Property{}
+		if err := result.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		for i, child := range result.Root.Children() {
+			if expected := prop.Root.Children()[i].Type(); child.Type() != expected {
+				t.Fatalf("unexpected type for %s: %s", expected.Name(), child.Type().Name())
+			}
+		}
+	}
+}
+
+func TestEncodingResolver(t *testing.T) {
+	prop, _ := NewProperty("root")
+	prop.Root.NewNodeWithValue("str", "テスト")
+	prop.Settings.Encoding = EncodingSJIS
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	data := wr.Bytes()
+	// a codepage that is not used by any standard encoding
+	data[2], data[3] = 7<<5, ^byte(7<<5)
+
+	result := &Property{}
+	if err := result.Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("unknown codepage was accepted")
+	}
+
+	result.Settings.EncodingResolver = func(codepage byte) *Encoding {
+		if codepage == 7 {
+			return EncodingSJIS
+		}
+		return nil
+	}
+	if err := result.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if result.Encoding() != EncodingSJIS {
+		t.Fatal("unexpected encoding:", result.Encoding())
+	}
+	if s := result.Root.SearchChild("str").StringValue(); s != "テスト" {
+		t.Fatal("unexpected value:", s)
+	}
+
+	// standard codepages are still used if the resolver returns nil
+	wr.Reset()
+	prop.Settings.Encoding = EncodingUTF8
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if result.Encoding() != EncodingUTF8 {
+		t.Fatal("unexpected encoding:", result.Encoding())
+	}
+}
+
+func TestStrictASCII(t *testing.T) {
+	prop, _ := NewProperty("root")
+	str, _ := prop.Root.NewNodeWithValue("str", "abcé😀")
+	prop.Settings.Encoding = EncodingASCII
+	for _, format := range []PropertyFormat{FormatBinary, FormatXML} {
+		prop.Settings.Format = format
+		prop.Settings.LenientASCII = false
+		prop.Settings.EncodeErrorPolicy = EncodeErrorFail
+		if err := prop.Write(io.Discard); err == nil {
+			t.Fatalf("%d: non-ASCII character was written", format)
+		}
+
+		prop.Settings.EncodeErrorPolicy = EncodeErrorReplace
+		wr := &bytes.Buffer{}
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		read := &Property{}
+		if err := read.Read(wr); err != nil {
+			t.Fatal(err)
+		}
+		if v := read.Root.ChildValue("str"); v != "abc??" {
+			t.Fatalf("%d: unexpected value: %v", format, v)
+		}
+
+		prop.Settings.LenientASCII = true
+		prop.Settings.EncodeErrorPolicy = EncodeErrorFail
+		wr.Reset()
+		if err := prop.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(wr.Bytes(), []byte("abcé😀")) {
+			t.Fatalf("%d: string was not written as-is", format)
+		}
+	}
+
+	// Latin-1 rejects characters outside of its range as well
+	prop.Settings.Encoding = EncodingLatin1
+	prop.Settings.Format = FormatBinary
+	if err := prop.Write(io.Discard); err == nil {
+		t.Fatal("non-Latin-1 character was written")
+	}
+	str.SetValue("abcé")
+	if err := prop.Write(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	prop := &Property{}
+	if err := prop.Read(strings.NewReader(`<root>
+		<u16 __type="u16">1</u16>
+		<arr __type="s32" __count="2">1 2</arr>
+		<vec __type="3f">1 2 3</vec>
+		<vecs __type="2u8" __count="2">1 2 3 4</vecs>
+		<str>hoge</str>
+		<info><bin __type="bin">dead</bin></info>
+	</root>`)); err != nil {
+		t.Fatal(err)
+	}
+	if errs := prop.CheckConsistency(); errs != nil {
+		t.Fatal("unexpected errors:", errs)
+	}
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	if err := prop.Read(wr); err != nil {
+		t.Fatal(err)
+	}
+	if errs := prop.CheckConsistency(); errs != nil {
+		t.Fatal("unexpected errors after binary round trip:", errs)
+	}
+
+	// values can be mistyped by modifying the tree incorrectly
+	prop.Root.SearchChild("u16").value = int32(1)
+	prop.Root.SearchChild("arr").value = []any{int32(1), "2"}
+	prop.Root.SearchChild("info").SearchChild("bin").value = "dead"
+	errs := prop.CheckConsistency()
+	if len(errs) != 3 {
+		t.Fatal("unexpected errors:", errs)
+	}
+	for i, path := range []string{"root/u16", "root/arr", "root/info/bin"} {
+		if !strings.Contains(errs[i].Error(), path+":") {
+			t.Fatalf("unexpected error for %s: %v", path, errs[i])
+		}
+	}
+}
+
+func TestLenientDecoding(t *testing.T) {
+	doc := []byte("<root><str>a\xffb\xe3\x81</str><ok attr=\"\xfe\">日本語</ok></root>")
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(doc)); err == nil {
+		t.Fatal("invalid UTF-8 was accepted")
+	}
+	prop.Settings.LenientDecoding = true
+	if err := prop.Read(bytes.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if s := prop.Root.ChildValue("str"); s != "a�b�" {
+		t.Fatalf("unexpected value: %q", s)
+	}
+	ok := prop.Root.SearchChild("ok")
+	if s := ok.StringValue(); s != "日本語" {
+		t.Fatalf("unexpected value: %q", s)
+	}
+	if s := ok.AttributeValue("attr"); s != "�" {
+		t.Fatalf("unexpected attribute: %q", s)
+	}
+
+	// documents in other encodings are decoded as usual
+	sjis, _ := EncodingSJIS.encoder().Bytes([]byte("テスト"))
+	doc = append([]byte(`<?xml version="1.0" encoding="SHIFT_JIS"?><root>`), sjis...)
+	doc = append(doc, "</root>"...)
+	if err := prop.Read(bytes.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if s := prop.Root.StringValue(); s != "テスト" {
+		t.Fatalf("unexpected value: %q", s)
+	}
+
+	// strings of binary documents
+	prop, _ = NewProperty("root")
+	prop.Root.NewNodeWithValue("str", "a\xffb")
+	prop.Settings.Encoding = EncodingUTF8
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	for _, lazy := range []bool{false, true} {
+		result := &Property{}
+		result.Settings.LenientDecoding = true
+		result.Settings.LazyStrings = lazy
+		if err := result.Read(bytes.NewReader(wr.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if s := result.Root.ChildValue("str"); s != "a�b" {
+			t.Fatalf("unexpected value: %q", s)
+		}
+	}
+
+	// every invalid sequence is replaced, as in XML documents
+	if s := sanitizeUTF8([]byte("a\xff\xfeb\xc0\x80")); s != "a��b��" {
+		t.Fatalf("unexpected value: %q", s)
+	}
+
+	// decoders that fail
+	strict := &Encoding{codepage: 5, name: "strict", charset: strictUTF8{}}
+	prop.Root.SearchChild("str").SetValue("a\xff\xfeb")
+	wr.Reset()
+	if err := prop.Write(wr); err != nil {
+		t.Fatal(err)
+	}
+	for _, lazy := range []bool{false, true} {
+		result := &Property{}
+		result.Settings.EncodingResolver = func(byte) *Encoding { return strict }
+		result.Settings.LazyStrings = lazy
+		if err := result.Read(bytes.NewReader(wr.Bytes())); err == nil && !lazy {
+			t.Fatal("invalid string was decoded")
+		}
+		result.Settings.LenientDecoding = true
+		if err := result.Read(bytes.NewReader(wr.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if s := result.Root.ChildValue("str"); s != "a��b" {
+			t.Fatalf("unexpected value: %q", s)
+		}
+	}
+}
+
+// strictUTF8 is a charset whose decoder fails on invalid UTF-8.
+type strictUTF8 struct{}
+
+func (strictUTF8) NewDecoder() *textencoding.Decoder {
+	return &textencoding.Decoder{Transformer: textencoding.UTF8Validator}
+}
+
+func (strictUTF8) NewEncoder() *textencoding.Encoder {
+	return &textencoding.Encoder{Transformer: textencoding.UTF8Validator}
+}
+
+func TestRandomProperty(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		prop := RandomProperty(rng, 4, 6)
+		if errs := prop.CheckConsistency(); errs != nil {
+			t.Fatal(errs)
+		}
+
+		for _, format := range []PropertyFormat{FormatBinary, FormatXML, FormatPrettyXML, FormatJSON} {
+			prop.Settings.Format = format
+			wr := &bytes.Buffer{}
+			if err := prop.Write(wr); err != nil {
+				t.Fatal(err)
+			}
+			read := &Property{}
+			if err := read.Read(wr); err != nil {
+				t.Fatalf("%d: %s: %v", i, format, err)
+			}
+			if !read.Root.Equals(prop.Root) {
+				t.Fatalf("%d: %s: trees are not equal:\n%s\n%s", i, format, prop.Root.Dump(), read.Root.Dump())
+			}
+		}
+	}
+}
+
+func TestDatabodyPool(t *testing.T) {
+	// buffers that are reused must not leak data between writes
+	large, _ := NewProperty("root")
+	large.Root.NewNodeWithValue("bin", BinValue(bytes.Repeat([]byte{0xFF}, 100)))
+	large.Root.NewNodeWithValue("u8", uint8(0xFF))
+	small, _ := NewProperty("root")
+	small.Root.NewNodeWithValue("u8", uint8(1))
+	small.Root.NewNodeWithValue("s16", int16(2))
+
+	expected := &bytes.Buffer{}
+	if err := small.Write(expected); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := large.Write(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		wr := &bytes.Buffer{}
+		if err := small.Write(wr); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wr.Bytes(), expected.Bytes()) {
+			t.Fatal("unexpected output after reusing buffer")
+		}
+	}
+}
+
+func TestReadDetect(t *testing.T) {
+	testcases := []struct {
+		data     []byte
+		expected DocumentInfo
+	}{
+		{testcaseBinary, DocumentInfo{FormatBinary, EncodingUTF8, false, false, false, 106, 2952, 1840}},
+		{testcaseBinaryLong, DocumentInfo{FormatBinary, EncodingUTF8, true, false, false, 106, 3164, 1840}},
+		{testcaseXML, DocumentInfo{FormatXML, EncodingUTF8, false, false, false, 106, 6428, 0}},
+	}
+	for _, testcase := range testcases {
+		// the reader is not a ByteScanner
+		prop, detected, err := ReadDetect(io.MultiReader(bytes.NewReader(testcase.data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected != testcase.expected {
+			t.Fatalf("unexpected settings: %+v", detected)
+		}
+		expected := &Property{}
+		if err := expected.Read(bytes.NewReader(testcase.data)); err != nil {
+			t.Fatal(err)
+		}
+		if !prop.Root.Equals(expected.Root) {
+			t.Fatal("trees are not equal")
+		}
+		if !reflect.DeepEqual(prop.Settings, PropertySettings{}) {
+			t.Fatalf("settings were modified: %+v", prop.Settings)
+		}
+	}
+
+	if _, _, err := ReadDetect(strings.NewReader("garbage")); err == nil {
+		t.Fatal("invalid document was read")
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	root, _ := NewNode("root")
+	root.SetAttribute("hoge", "fuga")
+	root.NewNodeWithValue("s32", int32(-5))
+	root.NewNodeWithValue("u8", []uint8{1, 2})
+	root.NewNodeWithValue("bin", BinValue{0xDE, 0xAD})
+	root.NewNodeWithValue("vec", [2]float32{1.5, 2})
+	root.NewNodeWithValue("ip", net.IPv4(10, 0, 0, 1))
+
+	b, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"root","attributes":{"hoge":"fuga"},"children":[` +
+		`{"name":"s32","__type":"s32","value":-5},` +
+		`{"name":"u8","__type":"u8","__count":2,"value":[1,2]},` +
+		`{"name":"bin","__type":"bin","value":"dead"},` +
+		`{"name":"vec","__type":"2f","value":[1.5,2]},` +
+		`{"name":"ip","__type":"ip4","value":"10.0.0.1"}]}`
+	if string(b) != expected {
+		t.Fatalf("unexpected json: %s", b)
+	}
+}
+
+func BenchmarkReadBinary(b *testing.B) {
+	prop := Property{}
+	rd := bytes.NewReader(testcaseXML)
+	for i := 0; i < b.N; i++ {
+		if err := prop.Read(rd); err != nil {
+			b.Fatal(err)
+		}
+		prop.Root = nil
+		rd.Reset(testcaseBinary)
+	}
+}
+
+func BenchmarkReadIntoBinary(b *testing.B) {
+	prop := Property{}
+	rd := bytes.NewReader(testcaseBinary)
+	for i := 0; i < b.N; i++ {
+		if err := prop.ReadInto(rd, prop.Root); err != nil {
+			b.Fatal(err)
+		}
+		rd.Reset(testcaseBinary)
+	}
+}
+
+func BenchmarkReadS32Array(b *testing.B) {
+	prop, _ := NewProperty("root")
+	prop.Settings.Format = FormatBinary
+	prop.Root.NewNodeWithValue("array", make([]int32, 100000))
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		b.Fatal(err)
+	}
+
+	rd := bytes.NewReader(wr.Bytes())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := prop.Read(rd); err != nil {
+			b.Fatal(err)
+		}
+		rd.Reset(wr.Bytes())
+	}
+}
+
+func benchmarkReadFile(b *testing.B, bufferSize int) {
+	prop, _ := NewProperty("root")
+	for i := 0; i < 10000; i++ {
+		prop.Root.NewNodeWithValue("v", strings.Repeat("a", 500))
+	}
+	filename := b.TempDir() + "/big.bin"
+	if err := prop.WriteFile(filename); err != nil {
+		b.Fatal(err)
+	}
+
+	prop.Settings.BufferSize = bufferSize
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := prop.ReadFile(filename); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadFileDefaultBuffer(b *testing.B) {
+	benchmarkReadFile(b, 0)
+}
+
+func BenchmarkReadFileLargeBuffer(b *testing.B) {
+	benchmarkReadFile(b, 1<<20)
+}
+
+func BenchmarkReadLazyStrings(b *testing.B) {
+	for _, lazy := range []bool{false, true} {
+		b.Run("lazy="+strconv.FormatBool(lazy), func(b *testing.B) {
+			prop, _ := NewProperty("root")
+			prop.SetEncoding(EncodingSJIS)
+			for i := 0; i < 5000; i++ {
+				prop.Root.NewNodeWithValue("s", strings.Repeat("日本語", 20))
+			}
+			wr := &bytes.Buffer{}
+			if err := prop.Write(wr); err != nil {
+				b.Fatal(err)
+			}
+
+			prop.Settings.LazyStrings = lazy
+			rd := bytes.NewReader(wr.Bytes())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := prop.Read(rd); err != nil {
+					b.Fatal(err)
+				}
+				prop.Root.Children()[0].StringValue()
+				rd.Reset(wr.Bytes())
+			}
+		})
+	}
+}
+
+func BenchmarkIndexGet(b *testing.B) {
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(testcaseBinary)); err != nil {
+		b.Fatal(err)
+	}
+	index := prop.BuildIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if index.Get("avs/entry_4b") == nil {
+			b.Fatal("node not found")
+		}
+	}
+}
+
+func BenchmarkSelectPath(b *testing.B) {
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(testcaseBinary)); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if prop.SelectPath("avs/entry_4b") == nil {
+			b.Fatal("node not found")
+		}
 	}
 }
 
@@ -166,6 +4167,7 @@ func BenchmarkWriteBinary(b *testing.B) {
 		Settings: PropertySettings{Format: FormatBinary},
 		Root:     testcaseNode,
 	}
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		if err := prop.Write(io.Discard); err != nil {
 			b.Fatal(err)
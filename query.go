@@ -0,0 +1,132 @@
+package avsproperty
+
+import "strings"
+
+// Query is the result of a lookup performed with Node.Q. All of its
+// methods are safe to call even if the lookup failed, in which case
+// they return the zero value of their respective types.
+type Query struct {
+	node *Node
+}
+
+// Q looks up the descendant of the Node at the specified path, which
+// consists of node names separated by slashes. Each segment refers to
+// the first child with a matching name. An empty path refers to
+// the Node itself.
+func (n *Node) Q(path string) Query {
+	if n == nil {
+		return Query{}
+	}
+
+	node := n
+	if path != "" {
+		for _, name := range strings.Split(path, "/") {
+			if node = node.SearchChild(name); node == nil {
+				break
+			}
+		}
+	}
+	return Query{node}
+}
+
+// SelectPath returns the descendant of the Node at the specified path,
+// or nil if it does not exist. Like with Q, each slash-separated segment
+// refers to the first child with a matching name, but empty segments are
+// ignored, so a leading slash or an empty path refer to the Node itself.
+func (n *Node) SelectPath(path string) *Node {
+	node := n
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		if node = node.SearchChild(name); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// SelectPath returns the Node at the specified path, which starts with
+// the name of the root Node, or nil if it does not exist. The path
+// returned by Node.Path can be used to find a Node again. Refer to
+// Node.SelectPath for the format of path.
+func (p *Property) SelectPath(path string) *Node {
+	if p.Root == nil {
+		return nil
+	}
+	root, rest, _ := strings.Cut(strings.TrimLeft(path, "/"), "/")
+	if name, err := searchName(root); err != nil || !p.Root.name.Equals(name) {
+		return nil
+	}
+	return p.Root.SelectPath(rest)
+}
+
+// Q looks up a descendant of the Query's node. Refer to Node.Q for
+// the format of path.
+func (q Query) Q(path string) Query {
+	return q.node.Q(path)
+}
+
+// Node returns the node that the Query refers to, or nil if
+// the lookup failed.
+func (q Query) Node() *Node {
+	return q.node
+}
+
+// Exists reports whether the lookup was successful.
+func (q Query) Exists() bool {
+	return q.node != nil
+}
+
+// Int returns the node's value as a signed integer. Unsigned
+// integer values are converted. If the node does not contain
+// an integer value, 0 is returned.
+func (q Query) Int() int64 {
+	if q.node == nil {
+		return 0
+	}
+	switch v := q.node.value.(type) {
+	case uint8, uint16, uint32, uint64:
+		return int64(q.node.UintValue())
+	case TimeValue:
+		return int64(v)
+	default:
+		return q.node.IntValue()
+	}
+}
+
+// Uint returns the node's value as an unsigned integer, or 0 if
+// the node does not contain an unsigned integer value.
+func (q Query) Uint() uint64 {
+	if q.node == nil {
+		return 0
+	}
+	return q.node.UintValue()
+}
+
+// Float returns the node's value as a floating-point number, or 0 if
+// the node does not contain a float or double value.
+func (q Query) Float() float64 {
+	if q.node == nil {
+		return 0
+	}
+	return q.node.FloatValue()
+}
+
+// String returns the node's value as a string, or an empty string if
+// the node does not contain a string value.
+func (q Query) String() string {
+	if q.node == nil {
+		return ""
+	}
+	return q.node.StringValue()
+}
+
+// Attribute returns the value of the node's attribute with the
+// specified key, or an empty string if it's not present.
+func (q Query) Attribute(k string) string {
+	if q.node == nil {
+		return ""
+	}
+	return q.node.AttributeValue(k)
+}
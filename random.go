@@ -0,0 +1,138 @@
+package avsproperty
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"strconv"
+)
+
+// RandomProperty builds a random Property for testing, whose tree is at
+// most maxDepth levels deep, and whose void Nodes have at most
+// maxChildren children. The Nodes are of random types, have random
+// names, attributes, and values, and are arrays at random, but the tree
+// can always be written in any format.
+func RandomProperty(rng *rand.Rand, maxDepth, maxChildren int) *Property {
+	prop := &Property{Root: &Node{
+		name:     randomNodeName(rng),
+		nodeType: VoidNode,
+	}}
+	randomChildren(rng, prop.Root, maxDepth-1, maxChildren)
+	return prop
+}
+
+func randomChildren(rng *rand.Rand, n *Node, depth, maxChildren int) {
+	randomAttributes(rng, n)
+	if depth <= 0 || maxChildren <= 0 {
+		return
+	}
+
+	for i := rng.Intn(maxChildren + 1); i > 0; i-- {
+		nt := randomNodeType(rng)
+		child := &Node{name: randomNodeName(rng), nodeType: nt}
+		n.AppendChild(child)
+		if nt == VoidNode {
+			randomChildren(rng, child, depth-1, maxChildren)
+			continue
+		}
+
+		if nt != StrNode && nt != BinNode && rng.Intn(4) == 0 {
+			elems := reflect.MakeSlice(reflect.SliceOf(nt.rt), rng.Intn(9), 8)
+			for i := 0; i < elems.Len(); i++ {
+				elems.Index(i).Set(randomValue(rng, nt))
+			}
+			child.value = elems.Interface()
+			child.isArray = true
+		} else {
+			child.value = randomValue(rng, nt).Interface()
+		}
+		randomAttributes(rng, child)
+	}
+}
+
+func randomAttributes(rng *rand.Rand, n *Node) {
+	for i := rng.Intn(3); i > 0; i-- {
+		n.SetAttribute(randomNodeName(rng).String(), randomString(rng))
+	}
+}
+
+// randomNodeType returns a random registered type, where void is as
+// likely as all other types combined.
+func randomNodeType(rng *rand.Rand) *NodeType {
+	if rng.Intn(2) == 0 {
+		return VoidNode
+	}
+	for {
+		if nt := idLut[rng.Intn(len(idLut))]; nt != nil && nt != VoidNode {
+			return nt
+		}
+	}
+}
+
+func randomNodeName(rng *rand.Rand) *NodeName {
+	// names that start with digits are not valid in XML documents
+	const (
+		letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+		charset = "0123456789_" + letters
+	)
+
+	b := make([]byte, 1+rng.Intn(12))
+	b[0] = letters[rng.Intn(len(letters))]
+	for i := 1; i < len(b); i++ {
+		b[i] = charset[rng.Intn(len(charset))]
+	}
+	name, _ := NewNodeName(string(b))
+	return name
+}
+
+func randomString(rng *rand.Rand) string {
+	// whitespace is avoided, since it may be trimmed
+	const charset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz<>&\"'!?-"
+
+	b := make([]byte, rng.Intn(16))
+	for i := range b {
+		b[i] = charset[rng.Intn(len(charset))]
+	}
+	return string(b)
+}
+
+// randomValue returns a random scalar value of the type.
+func randomValue(rng *rand.Rand, nt *NodeType) reflect.Value {
+	switch nt {
+	case StrNode:
+		return reflect.ValueOf(randomString(rng))
+	case BinNode:
+		b := make(BinValue, rng.Intn(16))
+		rng.Read(b)
+		return reflect.ValueOf(b)
+	case IPv4Node:
+		return reflect.ValueOf(net.IPv4(byte(rng.Intn(256)), byte(rng.Intn(256)),
+			byte(rng.Intn(256)), byte(rng.Intn(256))).To4())
+	}
+
+	v := reflect.New(nt.rt).Elem()
+	if v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			randomScalar(rng, v.Index(i))
+		}
+	} else {
+		randomScalar(rng, v)
+	}
+	return v
+}
+
+func randomScalar(rng *rand.Rand, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(rng.Intn(2) == 0)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(rng.Uint64()))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(rng.Uint64())
+	case reflect.Float32, reflect.Float64:
+		// values that can be represented exactly as floats,
+		// which makes them suitable for both float and double
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(rng.NormFloat64()*1000, 'f', 2, 32), 32)
+		v.SetFloat(f)
+	}
+}
@@ -0,0 +1,85 @@
+package avsproperty
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Schema maps slash-delimited node paths to the types of the nodes at
+// these paths. Paths start with the name of the root node, e.g.
+// "root/player/id".
+type Schema map[string]*NodeType
+
+// ReadSchema reads a Schema from a JSON document that consists of a
+// single object, which maps paths to type names:
+//
+//	{"root/player/id": "s32", "root/player/name": "str"}
+func ReadSchema(rd io.Reader) (Schema, error) {
+	var m map[string]string
+	if err := json.NewDecoder(rd).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	schema := make(Schema, len(m))
+	for path, name := range m {
+		nt := lookupTypeByName(name)
+		if nt == nil {
+			return nil, propertyError("invalid node type for " + path + ": " + name)
+		}
+		schema[path] = nt
+	}
+	return schema, nil
+}
+
+// ReadSchemaFile reads a Schema from a file at the specified
+// path. Refer to ReadSchema for the format of the file.
+func ReadSchemaFile(filename string) (Schema, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadSchema(f)
+}
+
+// Validate checks the tree at root against the Schema, and returns a list
+// of violations. At least one node must be present at every path in the
+// Schema, and each of these nodes must have the corresponding type.
+func (s Schema) Validate(root *Node) []error {
+	var (
+		errs  []error
+		path  []string
+		found = make(map[string]bool)
+	)
+	root.Traverse(func(n *Node) error {
+		path = append(path, n.name.String())
+		p := strings.Join(path, "/")
+		if nt, ok := s[p]; ok {
+			found[p] = true
+			if n.nodeType != nt {
+				errs = append(errs, propertyError(p+": expected type "+nt.Name()+", got "+n.nodeType.Name()))
+			}
+		}
+		return nil
+	}, func(*Node) error {
+		path = path[:len(path)-1]
+		return nil
+	})
+
+	missing := make([]string, 0)
+	for p := range s {
+		if !found[p] {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	for _, p := range missing {
+		errs = append(errs, propertyError(p+": node not found"))
+	}
+
+	return errs
+}
@@ -0,0 +1,31 @@
+package avsproperty
+
+import "bytes"
+
+// SubProperty parses the Node's bin value as an embedded property, which
+// may be in any of the formats that are supported by Property.Read.
+func (n *Node) SubProperty() (*Property, error) {
+	if n.nodeType != BinNode || n.isArray || n.value == nil {
+		return nil, n.error("node does not contain a bin value")
+	}
+
+	prop := &Property{}
+	if err := prop.Read(bytes.NewReader(n.BinaryValue())); err != nil {
+		return nil, n.valueError(err)
+	}
+	return prop, nil
+}
+
+// SetSubProperty serializes prop using its settings, and sets the Node's
+// value to the result as a bin value. Refer to SubProperty.
+func (n *Node) SetSubProperty(prop *Property) error {
+	if len(n.children) > 0 {
+		return n.error("cannot assign value to node that has children")
+	}
+
+	wr := &bytes.Buffer{}
+	if err := prop.Write(wr); err != nil {
+		return err
+	}
+	return n.SetValue(BinValue(wr.Bytes()))
+}
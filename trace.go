@@ -0,0 +1,40 @@
+package avsproperty
+
+// Trace contains optional callbacks that are invoked at the boundaries
+// of the sections of binary documents while they are read or written.
+// nil callbacks are ignored.
+type Trace struct {
+	// OnHeader is called after the header has been processed.
+	OnHeader func()
+	// OnMetadataDone is called after the metadata section has been
+	// processed, with the number of nodes in the tree.
+	OnMetadataDone func(nodeCount int)
+	// OnDatabodyDone is called after the databody section has been
+	// processed, with the size of the section, excluding its size
+	// field.
+	OnDatabodyDone func(bytes int)
+}
+
+func (t *Trace) header() {
+	if t != nil && t.OnHeader != nil {
+		t.OnHeader()
+	}
+}
+
+func (t *Trace) metadataDone(root *Node) {
+	if t == nil || t.OnMetadataDone == nil {
+		return
+	}
+	var count int
+	root.Traverse(func(*Node) error {
+		count++
+		return nil
+	}, nil)
+	t.OnMetadataDone(count)
+}
+
+func (t *Trace) databodyDone(bytes int) {
+	if t != nil && t.OnDatabodyDone != nil {
+		t.OnDatabodyDone(bytes)
+	}
+}
@@ -19,8 +19,8 @@ const (
 )
 
 type (
-	bytesToValue  func([]byte) (any, error)
-	valueToBytes  func(any, []byte)
+	bytesToValue  func(binary.ByteOrder, []byte) (any, error)
+	valueToBytes  func(binary.ByteOrder, any, []byte)
 	stringToValue func(string) (any, error)
 )
 
@@ -40,6 +40,21 @@ func (t NodeType) Name() string {
 	return t.names[0]
 }
 
+// ZeroValue returns the zero value of the type's Go type, which can be
+// assigned to Nodes of the type, e.g. int32(0) for s32, or 0.0.0.0 for
+// ip4. nil is returned for void.
+func (t *NodeType) ZeroValue() any {
+	switch t {
+	case VoidNode:
+		return nil
+	case BinNode:
+		return BinValue{}
+	case IPv4Node:
+		return net.IPv4zero.To4()
+	}
+	return reflect.Zero(t.rt).Interface()
+}
+
 type (
 	// BinValue represents the value of a binary node.
 	BinValue []byte
@@ -226,6 +241,15 @@ var (
 		56, []string{"vb", "16b"}, 16, 16, reflect.TypeOf([16]BoolValue{}), vectorBytesToValue[[16]any](1, boolBytesToValue), vectorValueToBytes(1, boolValueToBytes), vectorStringToValue[[16]any](boolStringToValue),
 	}
 
+	// Bitset16Node is a non-standard type that packs 16 bools into 2
+	// bytes, where the first element is stored in the most significant
+	// bit of the first byte. Since it shares its Go type with
+	// Vec16BoolNode, values of type [16]BoolValue are assigned
+	// Vec16BoolNode by Node.SetValue; refer to Node.ConvertType.
+	Bitset16Node = &NodeType{
+		57, []string{"bitset16"}, 2, 16, reflect.TypeOf([16]BoolValue{}), bitset16BytesToValue, bitset16ValueToBytes, vectorStringToValue[[16]any](boolStringToValue),
+	}
+
 	idLut = []*NodeType{
 		1: VoidNode,
 		S8Node,
@@ -288,6 +312,7 @@ var (
 		Vec3BoolNode,
 		Vec4BoolNode,
 		Vec16BoolNode,
+		Bitset16Node,
 	}
 	typeLut = map[reflect.Type]*NodeType{}
 	nameLut = map[string]*NodeType{}
@@ -296,7 +321,9 @@ var (
 func init() {
 	for _, t := range idLut {
 		if t != nil {
-			typeLut[t.rt] = t
+			if _, ok := typeLut[t.rt]; !ok {
+				typeLut[t.rt] = t
+			}
 
 			for _, name := range t.names {
 				nameLut[name] = t
@@ -309,6 +336,19 @@ func lookupTypeByName(name string) *NodeType {
 	return nameLut[name]
 }
 
+// lookupXMLType looks up the type referenced by the __type attribute of
+// an XML element. Some tools write the binary type id instead of the
+// name, so s is parsed as an id if no type with that name exists.
+func lookupXMLType(s string) *NodeType {
+	if nt := lookupTypeByName(s); nt != nil {
+		return nt
+	}
+	if id, err := strconv.ParseUint(s, 10, 8); err == nil {
+		return lookupTypeById(byte(id))
+	}
+	return nil
+}
+
 func lookupTypeById(id byte) *NodeType {
 	if int(id) >= len(idLut) {
 		return nil
@@ -316,55 +356,55 @@ func lookupTypeById(id byte) *NodeType {
 	return idLut[id]
 }
 
-func int8BytesToValue(b []byte) (any, error) {
+func int8BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
 	return int8(b[0]), nil
 }
 
-func uint8BytesToValue(b []byte) (any, error) {
+func uint8BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
 	return b[0], nil
 }
 
-func int16BytesToValue(b []byte) (any, error) {
-	return int16(binary.BigEndian.Uint16(b)), nil
+func int16BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return int16(o.Uint16(b)), nil
 }
 
-func uint16BytesToValue(b []byte) (any, error) {
-	return binary.BigEndian.Uint16(b), nil
+func uint16BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return o.Uint16(b), nil
 }
 
-func int32BytesToValue(b []byte) (any, error) {
-	return int32(binary.BigEndian.Uint32(b)), nil
+func int32BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return int32(o.Uint32(b)), nil
 }
 
-func uint32BytesToValue(b []byte) (any, error) {
-	return binary.BigEndian.Uint32(b), nil
+func uint32BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return o.Uint32(b), nil
 }
 
-func timeBytesToValue(b []byte) (any, error) {
-	return TimeValue(binary.BigEndian.Uint32(b)), nil
+func timeBytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return TimeValue(o.Uint32(b)), nil
 }
 
-func int64BytesToValue(b []byte) (any, error) {
-	return int64(binary.BigEndian.Uint64(b)), nil
+func int64BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return int64(o.Uint64(b)), nil
 }
 
-func uint64BytesToValue(b []byte) (any, error) {
-	return binary.BigEndian.Uint64(b), nil
+func uint64BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return o.Uint64(b), nil
 }
 
-func ip4BytesToValue(b []byte) (any, error) {
+func ip4BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
 	return net.IPv4(b[0], b[1], b[2], b[3]), nil
 }
 
-func floatBytesToValue(b []byte) (any, error) {
-	return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+func floatBytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return math.Float32frombits(o.Uint32(b)), nil
 }
 
-func doubleBytesToValue(b []byte) (any, error) {
-	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+func doubleBytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	return math.Float64frombits(o.Uint64(b)), nil
 }
 
-func boolBytesToValue(b []byte) (any, error) {
+func boolBytesToValue(o binary.ByteOrder, b []byte) (any, error) {
 	switch b[0] {
 	case 0:
 		return BoolValue(false), nil
@@ -376,68 +416,76 @@ func boolBytesToValue(b []byte) (any, error) {
 }
 
 func vectorBytesToValue[T [2]any | [3]any | [4]any | [8]any | [16]any](size int, f bytesToValue) bytesToValue {
-	return func(b []byte) (any, error) {
-		var o T
-		for i := 0; i < len(o); i++ {
-			v, err := f(b[i*size:])
+	return func(o binary.ByteOrder, b []byte) (any, error) {
+		var vec T
+		for i := 0; i < len(vec); i++ {
+			v, err := f(o, b[i*size:])
 			if err != nil {
 				return nil, err
 			}
-			o[i] = v
+			vec[i] = v
 		}
-		return o, nil
+		return vec, nil
+	}
+}
+
+func bitset16BytesToValue(o binary.ByteOrder, b []byte) (any, error) {
+	var vec [16]any
+	for i := range vec {
+		vec[i] = BoolValue(b[i/8]&(0x80>>(i%8)) != 0)
 	}
+	return vec, nil
 }
 
-func int8ValueToBytes(v any, b []byte) {
+func int8ValueToBytes(o binary.ByteOrder, v any, b []byte) {
 	b[0] = uint8(v.(int8))
 }
 
-func uint8ValueToBytes(v any, b []byte) {
+func uint8ValueToBytes(o binary.ByteOrder, v any, b []byte) {
 	b[0] = v.(uint8)
 }
 
-func int16ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint16(b, uint16(v.(int16)))
+func int16ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint16(b, uint16(v.(int16)))
 }
 
-func uint16ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint16(b, v.(uint16))
+func uint16ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint16(b, v.(uint16))
 }
 
-func int32ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint32(b, uint32(v.(int32)))
+func int32ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint32(b, uint32(v.(int32)))
 }
 
-func uint32ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint32(b, v.(uint32))
+func uint32ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint32(b, v.(uint32))
 }
 
-func timeValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint32(b, uint32(v.(TimeValue)))
+func timeValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint32(b, uint32(v.(TimeValue)))
 }
 
-func int64ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint64(b, uint64(v.(int64)))
+func int64ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint64(b, uint64(v.(int64)))
 }
 
-func uint64ValueToBytes(v any, b []byte) {
-	binary.BigEndian.PutUint64(b, v.(uint64))
+func uint64ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	o.PutUint64(b, v.(uint64))
 }
 
-func ip4ValueToBytes(v any, b []byte) {
+func ip4ValueToBytes(o binary.ByteOrder, v any, b []byte) {
 	copy(b, v.(net.IP).To4())
 }
 
-func floatValueToBytes(v any, b []byte) {
-	uint32ValueToBytes(math.Float32bits(v.(float32)), b)
+func floatValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	uint32ValueToBytes(o, math.Float32bits(v.(float32)), b)
 }
 
-func doubleValueToBytes(v any, b []byte) {
-	uint64ValueToBytes(math.Float64bits(v.(float64)), b)
+func doubleValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	uint64ValueToBytes(o, math.Float64bits(v.(float64)), b)
 }
 
-func boolValueToBytes(v any, b []byte) {
+func boolValueToBytes(o binary.ByteOrder, v any, b []byte) {
 	if v.(BoolValue) {
 		b[0] = 1
 	} else {
@@ -445,11 +493,21 @@ func boolValueToBytes(v any, b []byte) {
 	}
 }
 
+func bitset16ValueToBytes(o binary.ByteOrder, v any, b []byte) {
+	b[0], b[1] = 0, 0
+	vo := reflect.ValueOf(v)
+	for i := 0; i < vo.Len(); i++ {
+		if vo.Index(i).Interface().(BoolValue) {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+}
+
 func vectorValueToBytes(size int, f valueToBytes) valueToBytes {
-	return func(v any, b []byte) {
+	return func(o binary.ByteOrder, v any, b []byte) {
 		vo := reflect.ValueOf(v)
 		for i := 0; i < vo.Len(); i++ {
-			f(vo.Index(i).Interface(), b[i*size:])
+			f(o, vo.Index(i).Interface(), b[i*size:])
 		}
 	}
 }
@@ -2,55 +2,96 @@ package avsproperty
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/xml"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 )
 
-func readXML(prop *Property, rd io.Reader) error {
+// The decoder does not expand entities declared in a DTD. Directives
+// such as DOCTYPE are skipped, and since Decoder.Entity is left unset,
+// references to any entities other than the predefined XML entities
+// result in an error.
+func readXML(prop *Property, rd io.Reader, recycler *nodeRecycler) error {
 	prop.Settings.Format = FormatXML
 	prop.Settings.Encoding = EncodingUTF8
+	if prop.Settings.LenientDecoding {
+		rd = &utf8Sanitizer{rd: rd}
+	}
 	decoder := xml.NewDecoder(rd)
 	state := &xmlReadState{
-		decoder: decoder,
-		prop:    prop,
+		decoder:  decoder,
+		prop:     prop,
+		recycler: recycler,
 	}
 	decoder.CharsetReader = state.readCharset
 	return state.read()
 }
 
 type xmlReadState struct {
-	decoder *xml.Decoder
-	prop    *Property
+	decoder  *xml.Decoder
+	prop     *Property
+	recycler *nodeRecycler
+
+	node    *Node
+	count   int
+	depth   int
+	charset *Encoding
+
+	// whitespace that has not been assigned to a node yet
+	space string
+	// character data of the current element that has not been
+	// parsed yet, since it may be split across multiple tokens
+	text []byte
+}
 
-	node  *Node
-	count int
+// xmlWhitespace holds the whitespace surrounding the tags of an element
+// that was read using PropertySettings.PreserveWhitespace.
+type xmlWhitespace struct {
+	// before the start tag
+	before string
+	// before the end tag, if the element is void
+	end string
+	// after the end tag, if the element is the root
+	after string
 }
 
 func (state *xmlReadState) read() error {
+	max, tokens := state.prop.Settings.MaxXMLTokens, 0
 	for {
 		token, err := state.decoder.Token()
 		if err != nil {
 			if err == io.EOF {
+				if ws := state.prop.whitespace[state.prop.Root]; ws != nil {
+					ws.after = state.space
+				}
 				return nil
 			}
 			return err
 		}
 
+		if tokens++; max > 0 && tokens > max {
+			return propertyError("max number of xml tokens exceeded")
+		}
+
 		switch token := token.(type) {
 		case xml.StartElement:
 			err = state.readStartElement(token)
 
 		case xml.CharData:
-			if state.node == nil {
-				continue
+			if state.prop.whitespace != nil && (state.node == nil || state.node.nodeType == VoidNode) &&
+				len(bytes.TrimSpace(token)) == 0 {
+				state.space += string(token)
+			}
+			if state.node != nil {
+				state.text = append(state.text, token...)
 			}
-			err = state.readCharData(token)
 
 		case xml.EndElement:
-			state.node = state.node.parent
+			err = state.readEndElement()
 		}
 		if err != nil {
 			return err
@@ -59,10 +100,26 @@ func (state *xmlReadState) read() error {
 }
 
 func (state *xmlReadState) readStartElement(elem xml.StartElement) error {
+	if err := state.flushCharData(); err != nil {
+		return err
+	}
+	if state.depth++; state.depth > state.prop.maxDepth() {
+		return propertyError("max depth exceeded")
+	}
+
 	err := state.newNode(elem)
 	if err != nil {
 		return err
 	}
+	if state.prop.whitespace != nil {
+		state.prop.whitespace[state.node] = &xmlWhitespace{before: state.space}
+		state.space = ""
+	}
+	if hints := state.prop.Settings.TypeHints; hints != nil {
+		if nt, ok := hints[state.node.Path()]; ok {
+			state.setType(nt)
+		}
+	}
 
 	for _, attr := range elem.Attr {
 		if err := state.readAttrib(attr); err != nil {
@@ -73,29 +130,78 @@ func (state *xmlReadState) readStartElement(elem xml.StartElement) error {
 	return nil
 }
 
+func (state *xmlReadState) readEndElement() error {
+	if err := state.flushCharData(); err != nil {
+		return err
+	}
+	node := state.node
+	if nt := node.nodeType; nt != VoidNode && node.value == nil {
+		// the element is empty, so it gets a zero value
+		if node.isArray {
+			if state.count != 0 {
+				return state.countError(0)
+			}
+			node.value = make([]any, 0)
+		} else {
+			v, err := nt.btv(binary.BigEndian, make([]byte, nt.size))
+			if err != nil {
+				return err
+			}
+			node.value = v
+		}
+	}
+
+	if ws := state.prop.whitespace[node]; ws != nil {
+		if node.nodeType == VoidNode {
+			ws.end = state.space
+		}
+		state.space = ""
+	}
+
+	state.recycler.leave()
+	state.node = node.parent
+	state.depth--
+	return nil
+}
+
+// countError returns an error for an array value that has got fields
+// rather than the number declared by its __count attribute.
+func (state *xmlReadState) countError(got int) error {
+	nt := state.node.nodeType
+	expected := strconv.Itoa(state.count) + " elements of " + strconv.Itoa(nt.count)
+	if state.count <= math.MaxInt/nt.count {
+		expected = strconv.Itoa(nt.count * state.count)
+	}
+	return state.node.error("invalid number of elements in value: expected " +
+		expected + " fields, got " + strconv.Itoa(got))
+}
+
 func (state *xmlReadState) readAttrib(attr xml.Attr) (err error) {
 	node := state.node
 	nt := node.nodeType
-	switch attr.Name.Local {
+	name := attr.Name.Local
+	if state.prop.Settings.CaseInsensitiveMeta {
+		switch lower := strings.ToLower(name); lower {
+		case "__type", "__count", "__size":
+			name = lower
+		}
+	}
+	switch name {
 	case "__type":
-		nt = lookupTypeByName(attr.Value)
+		nt = lookupXMLType(attr.Value)
 		if nt == nil {
 			return node.error("invalid node type: " + attr.Value)
 		}
-		node.nodeType = nt
-
-		// these types support empty values
-		if nt == StrNode {
-			node.value = ""
-		} else if nt == BinNode {
-			node.value = BinValue{}
-		}
+		state.setType(nt)
 
 	case "__count":
 		if nt == VoidNode || nt == StrNode || nt == BinNode {
 			return node.error("__count attribute out of place")
 		}
 		state.count, err = strconv.Atoi(attr.Value)
+		if err == nil && state.count < 0 {
+			return node.error("invalid __count attribute: " + attr.Value)
+		}
 		node.isArray = true
 
 	case "__size":
@@ -106,20 +212,58 @@ func (state *xmlReadState) readAttrib(attr xml.Attr) (err error) {
 		}
 
 	default:
-		err = node.SetAttribute(attr.Name.Local, attr.Value)
+		err = node.SetAttribute(name, attr.Value)
 	}
 	return
 }
 
-func (state *xmlReadState) newNode(elem xml.StartElement) (err error) {
+func (state *xmlReadState) setType(nt *NodeType) {
+	node := state.node
+	node.nodeType = nt
+	node.value = nil
+
+	// these types support empty values
+	if nt == StrNode {
+		node.value = ""
+	} else if nt == BinNode {
+		node.value = BinValue{}
+	}
+}
+
+func (state *xmlReadState) newNode(elem xml.StartElement) error {
+	name, err := NewNodeName(elem.Name.Local)
+	if err != nil {
+		return err
+	}
+
+	node := state.recycler.next(state.node, name, nil, false)
+	if node == nil {
+		node = &Node{
+			name:     name,
+			nodeType: VoidNode,
+		}
+	}
+
 	if state.node == nil {
-		state.node, err = NewNode(elem.Name.Local)
-		state.prop.Root = state.node
-	} else {
-		state.node, err = state.node.NewNode(elem.Name.Local)
+		state.prop.Root = node
+	} else if err := state.node.AppendChild(node); err != nil {
+		return err
 	}
+	state.node = node
 
-	return
+	return nil
+}
+
+// flushCharData parses the character data that has been accumulated for
+// the current element. Entities and CDATA sections have already been
+// resolved by the decoder at this point.
+func (state *xmlReadState) flushCharData() error {
+	if len(state.text) == 0 {
+		return nil
+	}
+	err := state.readCharData(state.text)
+	state.text = state.text[:0]
+	return err
 }
 
 func (state *xmlReadState) readCharData(cd xml.CharData) error {
@@ -137,6 +281,12 @@ func (state *xmlReadState) readCharData(cd xml.CharData) error {
 	case StrNode:
 		state.node.value = string(cd)
 
+		e := EncodingNone
+		if state.charset != nil {
+			e = state.charset
+		}
+		state.prop.recordStringEncoding(state.node, e)
+
 	case BinNode:
 		b, err := hex.DecodeString(string(cd))
 		if err != nil {
@@ -145,10 +295,18 @@ func (state *xmlReadState) readCharData(cd xml.CharData) error {
 		state.node.value = BinValue(b)
 
 	default:
+		stv := stringToValueFunc(nt)
 		if state.node.isArray {
-			split := strings.Split(string(cd), " ")
-			if len(split) != nt.count*state.count {
-				return state.node.error("invalid number of elements in value")
+			// an empty array may still contain whitespace
+			var split []string
+			if len(cd) > 0 {
+				split = strings.Split(string(cd), " ")
+			}
+			// every element is made up of nt.count fields. The count is
+			// checked by division so that a huge __count can't overflow,
+			// which keeps the allocation and slicing below in range
+			if len(split)%nt.count != 0 || len(split)/nt.count != state.count {
+				return state.countError(len(split))
 			}
 
 			slice := make([]any, state.count)
@@ -161,7 +319,7 @@ func (state *xmlReadState) readCharData(cd xml.CharData) error {
 					s = split[i]
 				}
 
-				v, err := nt.stv(s)
+				v, err := stv(s)
 				if err != nil {
 					return err
 				}
@@ -169,7 +327,7 @@ func (state *xmlReadState) readCharData(cd xml.CharData) error {
 			}
 			state.node.value = slice
 		} else {
-			v, err := state.node.nodeType.stv(string(cd))
+			v, err := stv(string(cd))
 			if err != nil {
 				return err
 			}
@@ -186,5 +344,13 @@ func (state *xmlReadState) readCharset(charset string, rd io.Reader) (io.Reader,
 		return nil, propertyError("encoding not found")
 	}
 	state.prop.Settings.Encoding = encoding
-	return encoding.charset.NewDecoder().Reader(rd), nil
+	state.charset = encoding
+	if decoder := encoding.decoder(); decoder != nil {
+		// the decoder replaces invalid sequences itself
+		if s, ok := rd.(*utf8Sanitizer); ok {
+			rd = s.rd
+		}
+		return decoder.Reader(rd), nil
+	}
+	return rd, nil
 }
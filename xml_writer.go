@@ -8,8 +8,8 @@ import (
 	"net"
 	"reflect"
 	"strconv"
-
-	"golang.org/x/text/encoding"
+	"strings"
+	"unicode/utf8"
 )
 
 func writeXML(prop *Property, wr io.Writer) error {
@@ -17,8 +17,15 @@ func writeXML(prop *Property, wr io.Writer) error {
 	state := &xmlWriteState{
 		wr:       wr,
 		encoding: encoding,
-		encoder:  encoding.encoder(),
+		encoder:  newStringEncoder(encoding, &prop.Settings),
 		pretty:   prop.Settings.Format == FormatPrettyXML,
+		allowNil: prop.Settings.AllowNilValues,
+
+		multilineAttribs: prop.Settings.MultilineAttributes,
+		cdata:            prop.Settings.UseCDATA,
+	}
+	if prop.Settings.PreserveWhitespace {
+		state.whitespace = prop.whitespace
 	}
 
 	return state.write(prop.Root)
@@ -27,21 +34,31 @@ func writeXML(prop *Property, wr io.Writer) error {
 type xmlWriteState struct {
 	wr       io.Writer
 	encoding *Encoding
-	encoder  *encoding.Encoder
+	encoder  *stringEncoder
 	pretty   bool
+	allowNil bool
+
+	multilineAttribs int
+	multiline        bool
+	cdata            bool
+	whitespace       map[*Node]*xmlWhitespace
 
 	depth int
 }
 
 func (state *xmlWriteState) write(node *Node) error {
-	if err := state.writeDecl(); err != nil {
+	if err := state.writeDecl(state.pretty && state.whitespace[node] == nil); err != nil {
 		return err
 	}
 	return node.Traverse(state.startNode, state.endNode)
 }
 
 func (state *xmlWriteState) startNode(node *Node) error {
-	if state.pretty {
+	if ws := state.whitespace[node]; ws != nil {
+		if _, err := io.WriteString(state.wr, ws.before); err != nil {
+			return err
+		}
+	} else if state.pretty {
 		if err := state.writeIndent(); err != nil {
 			return err
 		}
@@ -60,7 +77,14 @@ func (state *xmlWriteState) startNode(node *Node) error {
 
 func (state *xmlWriteState) endNode(node *Node) (err error) {
 	state.depth--
-	if state.pretty && len(node.children) > 0 {
+	ws := state.whitespace[node]
+	if ws != nil {
+		if node.nodeType == VoidNode {
+			if _, err = io.WriteString(state.wr, ws.end); err != nil {
+				return
+			}
+		}
+	} else if state.pretty && len(node.children) > 0 {
 		if err = state.writeIndent(); err != nil {
 			return
 		}
@@ -76,7 +100,9 @@ func (state *xmlWriteState) endNode(node *Node) (err error) {
 		return
 	}
 
-	if state.pretty {
+	if ws != nil {
+		_, err = io.WriteString(state.wr, ws.after)
+	} else if state.pretty {
 		state.wr.(io.ByteWriter).WriteByte('\n')
 	}
 
@@ -84,12 +110,24 @@ func (state *xmlWriteState) endNode(node *Node) (err error) {
 }
 
 func (state *xmlWriteState) writeInnerNode(node *Node) error {
+	state.multiline = state.pretty && state.multilineAttribs > 0 &&
+		len(node.attributes) > state.multilineAttribs
+
 	if node.nodeType != VoidNode {
 		if err := state.writeAttrib("__type", node.nodeType.names[0], false); err != nil {
 			return err
 		}
 
-		if node.isArray || node.nodeType == BinNode {
+		if node.value == nil {
+			if !state.allowNil {
+				return node.error("node has a nil value")
+			}
+			if node.isArray {
+				if err := state.writeAttrib("__count", "0", false); err != nil {
+					return err
+				}
+			}
+		} else if node.isArray || node.nodeType == BinNode {
 			var (
 				name string
 				size int
@@ -118,10 +156,13 @@ func (state *xmlWriteState) writeInnerNode(node *Node) error {
 	}
 
 	if node.nodeType != VoidNode {
+		if node.value == nil {
+			return nil
+		}
 		return state.writeValue(node)
 	}
 
-	if state.pretty && len(node.children) > 0 {
+	if state.pretty && len(node.children) > 0 && state.whitespace[node] == nil {
 		if err := state.wr.(io.ByteWriter).WriteByte('\n'); err != nil {
 			return err
 		}
@@ -131,24 +172,48 @@ func (state *xmlWriteState) writeInnerNode(node *Node) error {
 }
 
 func (state *xmlWriteState) writeValue(node *Node) error {
-	if node.value == nil {
-		return propertyError("node has a nil value")
+	if _, err := node.loadBinary(); err != nil {
+		return err
 	}
-
-	rv := reflect.ValueOf(node.value)
+	rv := reflect.ValueOf(node.resolvedValue())
 	switch v := node.value.(type) {
 	case BinValue:
 		_, err := io.WriteString(state.wr, hex.EncodeToString(v))
 		return err
 
 	case string:
+		if state.cdata && useCDATA(v) {
+			return state.writeCDATA(v)
+		}
 		return state.writeString(v)
 
 	default:
+		if f, ok := lookupFormatter(node.nodeType); ok {
+			return state.writeFormatted(node, f)
+		}
 		return state.writeValueRecursive(rv)
 	}
 }
 
+func (state *xmlWriteState) writeFormatted(node *Node, f valueFormatter) error {
+	if !node.isArray {
+		return xml.EscapeText(state.wr, []byte(f.format(node.value)))
+	}
+
+	rv := reflect.ValueOf(node.value)
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			if err := state.wr.(io.ByteWriter).WriteByte(' '); err != nil {
+				return err
+			}
+		}
+		if err := xml.EscapeText(state.wr, []byte(f.format(rv.Index(i).Interface()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (state *xmlWriteState) writeValueRecursive(rv reflect.Value) error {
 	if v, ok := rv.Interface().(net.IP); ok {
 		_, err := io.WriteString(state.wr, v.String())
@@ -181,7 +246,17 @@ func (state *xmlWriteState) writeValueRecursive(rv reflect.Value) error {
 }
 
 func (state *xmlWriteState) writeAttrib(k, v string, encode bool) error {
-	if err := state.wr.(io.ByteWriter).WriteByte(' '); err != nil {
+	if state.multiline {
+		if err := state.wr.(io.ByteWriter).WriteByte('\n'); err != nil {
+			return err
+		}
+		// depth has already been incremented for the
+		// node, so the attributes are indented one level
+		// deeper than its start tag
+		if err := state.writeIndent(); err != nil {
+			return err
+		}
+	} else if err := state.wr.(io.ByteWriter).WriteByte(' '); err != nil {
 		return err
 	}
 	if _, err := io.WriteString(state.wr, k); err != nil {
@@ -205,20 +280,60 @@ func (state *xmlWriteState) writeAttrib(k, v string, encode bool) error {
 }
 
 func (state *xmlWriteState) writeString(s string) error {
-	var b []byte
-	if state.encoder == nil {
-		b = []byte(s)
-	} else {
-		encoded, err := state.encoder.Bytes([]byte(s))
-		if err != nil {
-			return err
+	// escaping has to happen before encoding, since
+	// xml.EscapeText only accepts UTF-8
+	escaped := &strings.Builder{}
+	if err := xml.EscapeText(escaped, []byte(s)); err != nil {
+		return err
+	}
+
+	b, err := state.encoder.encode(escaped.String())
+	if err != nil {
+		return err
+	}
+	_, err = state.wr.Write(b)
+	return err
+}
+
+func (state *xmlWriteState) writeCDATA(s string) error {
+	b, err := state.encoder.encode(s)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(state.wr, "<![CDATA["); err != nil {
+		return err
+	}
+	if _, err := state.wr.Write(b); err != nil {
+		return err
+	}
+	_, err = io.WriteString(state.wr, "]]>")
+	return err
+}
+
+// useCDATA reports whether s contains markup characters, and can be
+// written inside a CDATA section without changing its value.
+func useCDATA(s string) bool {
+	if !strings.ContainsAny(s, "<>&") || strings.Contains(s, "]]>") {
+		return false
+	}
+	for _, r := range s {
+		// carriage returns are normalized by XML parsers
+		if r == '\r' || !isXMLChar(r) {
+			return false
 		}
-		b = encoded
 	}
-	return xml.EscapeText(state.wr, b)
+	return true
+}
+
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD && r != utf8.RuneError ||
+		r >= 0x10000 && r <= utf8.MaxRune
 }
 
-func (state *xmlWriteState) writeDecl() (err error) {
+func (state *xmlWriteState) writeDecl(newline bool) (err error) {
 	if _, err = io.WriteString(state.wr, "<?xml version=\"1.0\""); err != nil {
 		return
 	}
@@ -237,7 +352,7 @@ func (state *xmlWriteState) writeDecl() (err error) {
 		return err
 	}
 
-	if state.pretty {
+	if newline {
 		err = state.wr.(io.ByteWriter).WriteByte('\n')
 	}
 	return